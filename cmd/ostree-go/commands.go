@@ -0,0 +1,616 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ostreedev/ostree-go/pkg/otbuiltin"
+)
+
+// lstatFS is implemented by the file systems of otbuiltin.OpenCommitFS
+type lstatFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+func runInit(e *env, args []string) error {
+	opts := otbuiltin.NewInitOptions()
+	var config stringList
+	e.withJSON()
+	e.flags.StringVar(&opts.Mode, "mode", opts.Mode, "repo mode: bare, archive-z2, bare-user, bare-user-only or bare-split-xattrs")
+	e.flags.StringVar(&opts.CollectionID, "collection-id", "", "collection ID of the repo")
+	e.flags.Var(&config, "config", "set config `SECTION.KEY=VALUE`; may be repeated")
+	if _, err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	if len(config) > 0 {
+		opts.Config = make(map[string]string, len(config))
+		for _, kv := range config {
+			i := strings.IndexByte(kv, '=')
+			if i < 0 {
+				return &usageError{fmt.Sprintf("invalid config %q, expected SECTION.KEY=VALUE", kv)}
+			}
+			opts.Config[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	result, err := otbuiltin.InitRepo(e.repo, opts)
+	if err != nil && err != otbuiltin.ErrRepoExists {
+		return err
+	}
+	return e.output(map[string]interface{}{
+		"created": result.Created,
+		"mode":    result.Mode,
+	}, func() error {
+		if !result.Created {
+			fmt.Fprintf(e.stderr, "repository already exists with mode %s\n", result.Mode)
+		}
+		return nil
+	})
+}
+
+func runCommit(e *env, args []string) error {
+	opts := otbuiltin.NewCommitOptions()
+	var branch, timestamp string
+	var tree, metadata, detachedMetadata, gpgSign, skip, skipRegexp stringList
+	e.withJSON()
+	e.flags.StringVar(&branch, "branch", "", "branch to commit to")
+	e.flags.StringVar(&branch, "b", "", "shorthand for --branch")
+	e.flags.StringVar(&opts.Subject, "subject", "", "one line subject")
+	e.flags.StringVar(&opts.Subject, "s", "", "shorthand for --subject")
+	e.flags.StringVar(&opts.Body, "body", "", "full description")
+	e.flags.StringVar(&opts.Body, "m", "", "shorthand for --body")
+	e.flags.StringVar(&opts.Parent, "parent", "", "parent commit, or \"none\"")
+	e.flags.Var(&tree, "tree", "overlay `dir=PATH`, `tar=TARFILE` or `ref=COMMIT`; may be repeated")
+	e.flags.Var(&metadata, "add-metadata-string", "add a `KEY=VALUE` metadata string; may be repeated")
+	e.flags.Var(&detachedMetadata, "add-detached-metadata-string", "add a `KEY=VALUE` detached metadata string; may be repeated")
+	e.flags.IntVar(&opts.OwnerUID, "owner-uid", opts.OwnerUID, "set file ownership to user id")
+	e.flags.IntVar(&opts.OwnerGID, "owner-gid", opts.OwnerGID, "set file ownership to group id")
+	e.flags.BoolVar(&opts.NoXattrs, "no-xattrs", false, "do not import extended attributes")
+	e.flags.BoolVar(&opts.LinkCheckoutSpeedup, "link-checkout-speedup", false, "optimize for commits of trees composed of hardlinks into the repo")
+	e.flags.BoolVar(&opts.TarAutoCreateParents, "tar-autocreate-parents", false, "automatically create parent directories of tar entries")
+	e.flags.BoolVar(&opts.SkipIfUnchanged, "skip-if-unchanged", false, "do nothing if the contents are unchanged from the parent")
+	e.flags.StringVar(&opts.StatOverrideFile, "statoverride", "", "`FILE` of permission and ownership overrides of paths")
+	e.flags.StringVar(&opts.SkipListFile, "skip-list", "", "`FILE` of .gitignore-style patterns of paths to skip")
+	e.flags.Var(&skip, "skip", ".gitignore-style `PATTERN` of paths to skip; may be repeated")
+	e.flags.Var(&skipRegexp, "skip-regexp", "`REGEXP` of paths to skip; may be repeated")
+	e.flags.BoolVar(&opts.AllowUnmatchedSkips, "allow-unmatched-skips", false, "do not fail if a skip pattern matches no path")
+	e.flags.BoolVar(&opts.GenerateSizes, "generate-sizes", false, "generate size information in the commit metadata")
+	e.flags.BoolVar(&opts.CanonicalPermissions, "canonical-permissions", false, "commit files owned by root without setuid, setgid or group and other write bits")
+	e.flags.BoolVar(&opts.Consume, "consume", false, "delete the files of PATH as they are committed")
+	e.flags.StringVar(&opts.SelinuxPolicy, "selinux-policy", "", "label files with the SELinux policy of the tree at `PATH`")
+	e.flags.BoolVar(&opts.ErrorOnUnlabeled, "error-on-unlabeled", false, "fail if a file has no SELinux label")
+	e.flags.BoolVar(&opts.DevinoCanonical, "devino-canonical", false, "assume files checked out from the repo have unchanged metadata")
+	e.flags.Var(&gpgSign, "gpg-sign", "GPG `KEY-ID` to sign the commit with; may be repeated")
+	e.flags.StringVar(&opts.GpgHomedir, "gpg-homedir", "", "GPG home directory")
+	e.flags.StringVar(&timestamp, "timestamp", "", "override the timestamp of the commit, in RFC 3339 format")
+	e.flags.BoolVar(&opts.Orphan, "orphan", false, "create a commit without a parent, setting BRANCH only if given")
+	e.flags.BoolVar(&opts.Fsync, "fsync", opts.Fsync, "fsync written objects")
+	e.flags.BoolVar(&opts.CollectionBinding, "bind-collection", false, "bind the commit to the collection ID of the repo and to the branch")
+	rest, err := e.parse(args, 0, 1)
+	if err != nil {
+		return err
+	}
+	opts.Tree = tree
+	opts.AddMetadataString = metadata
+	opts.AddDetachedMetadataString = detachedMetadata
+	opts.GpgSign = gpgSign
+	opts.SkipPatterns = skip
+	opts.SkipRegexps = skipRegexp
+	if timestamp != "" {
+		if opts.Timestamp, err = time.Parse(time.RFC3339, timestamp); err != nil {
+			return &usageError{fmt.Sprintf("invalid timestamp: %s", err)}
+		}
+	}
+	// Like ostree, the current directory is committed by default
+	var commitPath string
+	if len(rest) > 0 {
+		commitPath = rest[0]
+	}
+
+	repo, err := otbuiltin.OpenRepo(e.repo)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	result, err := repo.Commit(commitPath, branch, opts)
+	if err != nil {
+		return err
+	}
+
+	return e.output(commitEntry{
+		Checksum: result.Checksum,
+		Branch:   branch,
+		Parent:   result.Parent,
+		RootTree: result.RootTree,
+		Skipped:  result.Skipped,
+	}, func() error {
+		_, err := fmt.Fprintln(e.stdout, result.Checksum)
+		return err
+	})
+}
+
+// commitEntry is the JSON output of the commit command
+type commitEntry struct {
+	Checksum string `json:"checksum"`
+	Branch   string `json:"branch,omitempty"`
+	Parent   string `json:"parent,omitempty"`
+	RootTree string `json:"root_tree"`
+	Skipped  bool   `json:"skipped"`
+}
+
+func runCheckout(e *env, args []string) error {
+	opts := otbuiltin.NewCheckoutOptions()
+	e.flags.BoolVar(&opts.UserMode, "user-mode", false, "do not change file ownership or initialize extended attributes")
+	e.flags.BoolVar(&opts.UserMode, "U", false, "shorthand for --user-mode")
+	e.flags.BoolVar(&opts.Union, "union", false, "keep existing directories, overwrite existing files")
+	e.flags.BoolVar(&opts.AllowNoent, "allow-noent", false, "do nothing if the specified path does not exist")
+	e.flags.BoolVar(&opts.DisableCache, "disable-cache", false, "do not update or use the internal uncompressed object cache")
+	e.flags.BoolVar(&opts.Whiteouts, "whiteouts", false, "process docker style whiteout entries")
+	e.flags.BoolVar(&opts.RequireHardlinks, "require-hardlinks", false, "do not fall back to full copies if hardlinking fails")
+	e.flags.StringVar(&opts.Subpath, "subpath", "", "check out a sub-directory")
+	e.flags.StringVar(&opts.FromFile, "from-file", "", "process many checkouts from `FILE`")
+	rest, err := e.parse(args, 2, 2)
+	if err != nil {
+		return err
+	}
+	return otbuiltin.Checkout(e.repo, rest[1], rest[0], opts)
+}
+
+// logEntryJSON is the JSON form of an otbuiltin.LogEntry
+type logEntryJSON struct {
+	Checksum  string     `json:"checksum"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	Body      string     `json:"body,omitempty"`
+	Variant   string     `json:"variant,omitempty"`
+}
+
+func runLog(e *env, args []string) error {
+	opts := otbuiltin.NewLogOptions()
+	e.withJSON()
+	e.flags.BoolVar(&opts.Raw, "raw", false, "show raw variant data")
+	rest, err := e.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	entries, err := otbuiltin.Log(e.repo, rest[0], opts)
+	if err != nil {
+		return err
+	}
+	// Log returns the oldest commit first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	jsonEntries := make([]logEntryJSON, len(entries))
+	for i, entry := range entries {
+		jsonEntries[i] = logEntryJSON{
+			Checksum: string(entry.Checksum),
+			Subject:  entry.Subject,
+			Body:     entry.Body,
+			Variant:  string(entry.Variant),
+		}
+		if !entry.Timestamp.IsZero() {
+			timestamp := entry.Timestamp.UTC()
+			jsonEntries[i].Timestamp = &timestamp
+		}
+	}
+	return e.output(jsonEntries, func() error {
+		for _, entry := range entries {
+			if _, err := fmt.Fprint(e.stdout, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func runPrune(e *env, args []string) error {
+	opts := otbuiltin.NewPruneOptions()
+	var keepYoungerThan string
+	var staticDeltasOnly bool
+	e.withJSON()
+	e.flags.BoolVar(&opts.NoPrune, "no-prune", false, "only display unreachable objects; don't delete")
+	e.flags.BoolVar(&opts.RefsOnly, "refs-only", false, "only compute reachability via refs")
+	e.flags.StringVar(&opts.DeleteCommit, "delete-commit", "", "delete `COMMIT`")
+	e.flags.StringVar(&keepYoungerThan, "keep-younger-than", "", "prune all commits older than this RFC 3339 `DATE`")
+	e.flags.IntVar(&opts.Depth, "depth", opts.Depth, "only traverse this many parents of each commit (-1=infinite)")
+	e.flags.BoolVar(&staticDeltasOnly, "static-deltas-only", false, "only prune static deltas with --delete-commit or --keep-younger-than")
+	if _, err := e.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	if keepYoungerThan != "" {
+		var err error
+		if opts.KeepYoungerThan, err = time.Parse(time.RFC3339, keepYoungerThan); err != nil {
+			return &usageError{fmt.Sprintf("invalid date: %s", err)}
+		}
+	}
+	if staticDeltasOnly {
+		opts.StaticDeltasOnly = 1
+	}
+
+	summary, err := otbuiltin.Prune(e.repo, opts)
+	if err != nil {
+		return err
+	}
+	return e.output(map[string]string{"summary": summary}, func() error {
+		_, err := fmt.Fprintln(e.stdout, summary)
+		return err
+	})
+}
+
+func runRefs(e *env, args []string) error {
+	e.withJSON()
+	rest, err := e.parse(args, 0, 1)
+	if err != nil {
+		return err
+	}
+	var prefix string
+	if len(rest) > 0 {
+		prefix = rest[0]
+	}
+
+	repo, err := otbuiltin.OpenRepo(e.repo)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	refs, err := repo.ListRefs(prefix)
+	if err != nil {
+		return err
+	}
+	return e.output(refs, func() error {
+		names := make([]string, 0, len(refs))
+		for ref := range refs {
+			names = append(names, ref)
+		}
+		sort.Strings(names)
+		for _, ref := range names {
+			if _, err := fmt.Fprintln(e.stdout, ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func runShow(e *env, args []string) error {
+	e.withJSON()
+	rest, err := e.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := otbuiltin.OpenRepo(e.repo)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	checksum, commit, err := repo.ReadCommitObject(rest[0])
+	if err != nil {
+		return err
+	}
+	return e.output(map[string]interface{}{
+		"checksum":  checksum,
+		"parent":    commit.Parent,
+		"subject":   commit.Subject,
+		"body":      commit.Body,
+		"timestamp": commit.Timestamp.UTC(),
+		"root_tree": commit.RootTree,
+		"root_meta": commit.RootMeta,
+	}, func() error {
+		fmt.Fprintf(e.stdout, "commit %s\n", checksum)
+		if commit.Parent != "" {
+			fmt.Fprintf(e.stdout, "Parent:  %s\n", commit.Parent)
+		}
+		fmt.Fprintf(e.stdout, "Date:  %s\n\n", commit.Timestamp.UTC().Format("2006-01-02 15:04:05 -0700"))
+		fmt.Fprintf(e.stdout, "    %s\n", commit.Subject)
+		if commit.Body != "" {
+			fmt.Fprintln(e.stdout)
+			for _, line := range strings.Split(commit.Body, "\n") {
+				fmt.Fprintf(e.stdout, "    %s\n", line)
+			}
+		}
+		_, err := fmt.Fprintln(e.stdout)
+		return err
+	})
+}
+
+// lsEntry is a file listed by ls
+type lsEntry struct {
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	Mode     uint32 `json:"mode"`
+	UID      uint32 `json:"uid"`
+	GID      uint32 `json:"gid"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	Target   string `json:"target,omitempty"`
+}
+
+func runLs(e *env, args []string) error {
+	var recursive, checksums bool
+	e.withJSON()
+	e.flags.BoolVar(&recursive, "R", false, "list directories recursively")
+	e.flags.BoolVar(&checksums, "C", false, "show checksums")
+	rest, err := e.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+	paths := rest[1:]
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	fsys, err := openCommitFS(e.repo, rest[0])
+	if err != nil {
+		return err
+	}
+
+	var entries []lsEntry
+	for _, p := range paths {
+		name := fsName(p)
+		info, err := fsys.Lstat(name)
+		if err != nil {
+			return err
+		}
+		entry, err := newLsEntry(fsys, name, info)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		if !info.IsDir() {
+			continue
+		}
+
+		err = fs.WalkDir(fsys, name, func(child string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if child == name {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entry, err := newLsEntry(fsys, child, info)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			if d.IsDir() && !recursive {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.output(entries, func() error {
+		for _, entry := range entries {
+			line := fmt.Sprintf("%s0%04o %d %d %6d ", entry.Type[:1], entry.Mode&07777, entry.UID, entry.GID, entry.Size)
+			if checksums {
+				line += entry.Checksum + " "
+			}
+			line += entry.Path
+			if entry.Target != "" {
+				line += " -> " + entry.Target
+			}
+			if _, err := fmt.Fprintln(e.stdout, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// newLsEntry returns the listing of the file name of fsys
+func newLsEntry(fsys lstatFS, name string, info fs.FileInfo) (lsEntry, error) {
+	sys := info.Sys().(*otbuiltin.CommitFileSys)
+	entry := lsEntry{
+		Path:     "/",
+		Mode:     unixMode(info.Mode()),
+		UID:      sys.UID,
+		GID:      sys.GID,
+		Size:     info.Size(),
+		Checksum: sys.Checksum,
+	}
+	if name != "." {
+		entry.Path = "/" + name
+	}
+	switch {
+	case info.IsDir():
+		entry.Type = "d"
+	case info.Mode()&fs.ModeSymlink != 0:
+		entry.Type = "l"
+		target, err := fsys.(interface{ ReadLink(string) (string, error) }).ReadLink(name)
+		if err != nil {
+			return entry, err
+		}
+		entry.Target = target
+	default:
+		entry.Type = "-"
+	}
+	return entry, nil
+}
+
+func runCat(e *env, args []string) error {
+	rest, err := e.parse(args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	fsys, err := openCommitFS(e.repo, rest[0])
+	if err != nil {
+		return err
+	}
+	for _, p := range rest[1:] {
+		f, err := fsys.Open(fsName(p))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(e.stdout, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntry is a difference between two commits
+type diffEntry struct {
+	Status string `json:"status"`
+	Path   string `json:"path"`
+}
+
+func runDiff(e *env, args []string) error {
+	e.withJSON()
+	rest, err := e.parse(args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	from, err := openCommitFS(e.repo, rest[0])
+	if err != nil {
+		return err
+	}
+	to, err := openCommitFS(e.repo, rest[1])
+	if err != nil {
+		return err
+	}
+
+	var entries []diffEntry
+	// Modified and deleted files are found walking the old tree, added files
+	// walking the new one.  The contents of added or deleted directories are
+	// not listed.
+	err = fs.WalkDir(from, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		oldInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		newInfo, err := to.Lstat(name)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			entries = append(entries, diffEntry{"D", "/" + name})
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if modified(oldInfo, newInfo) {
+			entries = append(entries, diffEntry{"M", "/" + name})
+		}
+		if d.IsDir() && !newInfo.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = fs.WalkDir(to, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, err := from.Lstat(name); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			entries = append(entries, diffEntry{"A", "/" + name})
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	return e.output(entries, func() error {
+		for _, entry := range entries {
+			if _, err := fmt.Fprintf(e.stdout, "%s    %s\n", entry.Status, entry.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// modified reports whether a file differs between two commits: content
+// checksums cover the metadata of files, dirmeta checksums that of
+// directories
+func modified(oldInfo, newInfo fs.FileInfo) bool {
+	if oldInfo.IsDir() != newInfo.IsDir() {
+		return true
+	}
+	oldSys := oldInfo.Sys().(*otbuiltin.CommitFileSys)
+	newSys := newInfo.Sys().(*otbuiltin.CommitFileSys)
+	if oldInfo.IsDir() {
+		return oldSys.MetaChecksum != newSys.MetaChecksum
+	}
+	return oldSys.Checksum != newSys.Checksum
+}
+
+// openCommitFS opens the commit rev of the repo at repoPath as a file
+// system.  The file system remains valid once the repo is closed.
+func openCommitFS(repoPath, rev string) (lstatFS, error) {
+	repo, err := otbuiltin.OpenRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	fsys, err := repo.OpenCommitFS(rev)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.(lstatFS), nil
+}
+
+// fsName converts an absolute path of a commit to an fs.FS name
+func fsName(p string) string {
+	name := strings.Trim(path.Clean("/"+p), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// unixMode converts an fs.FileMode to unix permission bits
+func unixMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
@@ -0,0 +1,173 @@
+// Command ostree-go manages ostree repositories through the otbuiltin
+// package, so that scripts go through the same code paths as the Go
+// services using it.
+//
+// Usage:
+//
+//	ostree-go COMMAND [--repo=PATH] [--json] [OPTIONS] [ARGS]
+//
+// The repo defaults to $OSTREE_REPO.  With --json, commands write a single
+// JSON document to stdout instead of text.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of the tool
+type command struct {
+	summary string
+	run     func(env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"init":     {"Initialize a new empty repository", runInit},
+	"commit":   {"Commit a new revision", runCommit},
+	"checkout": {"Check out a commit into a filesystem tree", runCheckout},
+	"log":      {"Show the log of a branch", runLog},
+	"prune":    {"Search for unreachable objects", runPrune},
+	"refs":     {"List refs", runRefs},
+	"show":     {"Show a commit", runShow},
+	"ls":       {"List the files of a commit", runLs},
+	"cat":      {"Concatenate the contents of files of a commit", runCat},
+	"diff":     {"Compare the trees of two commits", runDiff},
+}
+
+// errBadFlags is returned when the flags of a command fail to parse; the
+// flag package has already reported it
+var errBadFlags = errors.New("invalid flags")
+
+// usageError is an error in the command line
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// env holds the state shared by a command run
+type env struct {
+	name   string
+	stdout io.Writer
+	stderr io.Writer
+	flags  *flag.FlagSet
+	repo   string
+	json   bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit status: 0 on
+// success, 1 on failure and 2 on usage errors
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "ostree-go: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+
+	e := &env{name: args[0], stdout: stdout, stderr: stderr}
+	e.flags = flag.NewFlagSet("ostree-go "+args[0], flag.ContinueOnError)
+	e.flags.SetOutput(stderr)
+	e.flags.StringVar(&e.repo, "repo", os.Getenv("OSTREE_REPO"), "path to the repo (default $OSTREE_REPO)")
+
+	err := cmd.run(e, args[1:])
+	var uerr *usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errBadFlags):
+		return 2
+	case errors.As(err, &uerr):
+		fmt.Fprintf(stderr, "ostree-go %s: %s\n", args[0], uerr.msg)
+		e.flags.Usage()
+		return 2
+	default:
+		fmt.Fprintf(stderr, "ostree-go %s: %s\n", args[0], err)
+		return 1
+	}
+}
+
+// usage writes the list of commands
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: ostree-go COMMAND [--repo=PATH] [--json] [OPTIONS] [ARGS]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+// withJSON registers the --json flag
+func (e *env) withJSON() {
+	e.flags.BoolVar(&e.json, "json", false, "write JSON output")
+}
+
+// parse parses the flags of the command and checks the number of
+// positional arguments is between min and max, or at least min if max is
+// negative
+func (e *env) parse(args []string, min, max int) ([]string, error) {
+	if err := e.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errBadFlags
+	}
+	if e.repo == "" {
+		return nil, &usageError{"--repo must be specified"}
+	}
+
+	rest := e.flags.Args()
+	if len(rest) < min || (max >= 0 && len(rest) > max) {
+		return nil, &usageError{"wrong number of arguments"}
+	}
+	return rest, nil
+}
+
+// output writes v as JSON with --json, or calls text otherwise
+func (e *env) output(v interface{}, text func() error) error {
+	if e.json {
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	return text()
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
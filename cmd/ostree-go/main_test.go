@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// runOK runs a command line, failing the test if it does not succeed, and
+// returns its output
+func runOK(t *testing.T, args ...string) string {
+	var stdout, stderr bytes.Buffer
+	if status := run(args, &stdout, &stderr); status != 0 {
+		t.Fatalf("%v exited with status %d: %s", args, status, stderr.String())
+	}
+	return stdout.String()
+}
+
+// runJSON runs a command line with --json and decodes its output into v
+func runJSON(t *testing.T, v interface{}, args ...string) {
+	out := runOK(t, append(args, "--json")...)
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("failed to decode output of %v: %s\n%s", args, err, out)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "ostree-go-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	treeDir := path.Join(baseDir, "tree")
+	if err := os.MkdirAll(path.Join(treeDir, "etc"), 0755); err != nil {
+		t.Fatalf("failed to create tree: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(treeDir, "etc", "motd"), []byte("welcome\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(treeDir, "etc", "issue"), []byte("issue\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	var initResult struct {
+		Created bool
+		Mode    string
+	}
+	runJSON(t, &initResult, "init", "--repo", repoDir, "--mode", "archive-z2")
+	if !initResult.Created || initResult.Mode != "archive-z2" {
+		t.Errorf("unexpected init result %+v", initResult)
+	}
+	runJSON(t, &initResult, "init", "--repo", repoDir)
+	if initResult.Created {
+		t.Error("expected the existing repo not to be created again")
+	}
+
+	first := strings.TrimSpace(runOK(t, "commit", "--repo", repoDir, "-b", "main", "-s", "first", treeDir))
+	if err := ioutil.WriteFile(path.Join(treeDir, "etc", "motd"), []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Remove(path.Join(treeDir, "etc", "issue")); err != nil {
+		t.Fatalf("failed to remove file: %s", err)
+	}
+	if err := os.Mkdir(path.Join(treeDir, "usr"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+	var commitResult commitEntry
+	runJSON(t, &commitResult, "commit", "--repo", repoDir, "-b", "main", "-s", "second", "-m", "body", treeDir)
+	second := commitResult.Checksum
+	if commitResult.Parent != first || commitResult.Skipped || commitResult.RootTree == "" {
+		t.Errorf("unexpected commit result %+v", commitResult)
+	}
+	runJSON(t, &commitResult, "commit", "--repo", repoDir, "-b", "main", "--skip-if-unchanged", treeDir)
+	if !commitResult.Skipped || commitResult.Checksum != second {
+		t.Errorf("expected an unchanged commit to be skipped, got %+v", commitResult)
+	}
+
+	var refs map[string]string
+	runJSON(t, &refs, "refs", "--repo", repoDir)
+	if len(refs) != 1 || refs["main"] != second {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	if out := runOK(t, "refs", "--repo", repoDir); out != "main\n" {
+		t.Errorf("unexpected refs output %q", out)
+	}
+
+	var show map[string]string
+	runJSON(t, &show, "show", "--repo", repoDir, "main")
+	if show["checksum"] != second || show["parent"] != first || show["subject"] != "second" || show["body"] != "body" {
+		t.Errorf("unexpected commit %v", show)
+	}
+
+	var log []map[string]string
+	runJSON(t, &log, "log", "--repo", repoDir, "main")
+	if len(log) != 2 || log[0]["checksum"] != second || log[1]["subject"] != "first" {
+		t.Errorf("unexpected log %v", log)
+	}
+
+	if out := runOK(t, "cat", "--repo", repoDir, first, "/etc/motd", "etc/issue"); out != "welcome\nissue\n" {
+		t.Errorf("unexpected cat output %q", out)
+	}
+
+	var ls []lsEntry
+	runJSON(t, &ls, "ls", "--repo", repoDir, "-R", first)
+	var paths []string
+	for _, entry := range ls {
+		paths = append(paths, entry.Type+entry.Path)
+	}
+	if strings.Join(paths, " ") != "d/ d/etc -/etc/issue -/etc/motd" {
+		t.Errorf("unexpected listing %v", paths)
+	}
+	if out := runOK(t, "ls", "--repo", repoDir, first, "/etc/motd"); !strings.HasPrefix(out, "-00644 ") || !strings.HasSuffix(out, " 8 /etc/motd\n") {
+		t.Errorf("unexpected ls output %q", out)
+	}
+
+	var diff []diffEntry
+	runJSON(t, &diff, "diff", "--repo", repoDir, first, second)
+	if len(diff) != 3 || diff[0] != (diffEntry{"D", "/etc/issue"}) || diff[1] != (diffEntry{"M", "/etc/motd"}) || diff[2] != (diffEntry{"A", "/usr"}) {
+		t.Errorf("unexpected diff %v", diff)
+	}
+
+	checkoutDir := path.Join(baseDir, "checkout")
+	runOK(t, "checkout", "--repo", repoDir, "-U", "main", checkoutDir)
+	if data, err := ioutil.ReadFile(path.Join(checkoutDir, "etc", "motd")); err != nil || string(data) != "changed\n" {
+		t.Errorf("unexpected checked out content %q: %v", data, err)
+	}
+
+	var prune map[string]string
+	runJSON(t, &prune, "prune", "--repo", repoDir, "--no-prune")
+	if !strings.HasPrefix(prune["summary"], "Total objects: ") {
+		t.Errorf("unexpected prune summary %q", prune["summary"])
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"refs", "--no-such-flag"},
+		{"show", "--repo", "/nonexistent"},
+		{"diff", "--repo", "/nonexistent", "a"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if status := run(args, &stdout, &stderr); status != 2 {
+			t.Errorf("expected %v to exit with status 2, got %d", args, status)
+		}
+	}
+
+	os.Unsetenv("OSTREE_REPO")
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"refs"}, &stdout, &stderr); status != 2 || !strings.Contains(stderr.String(), "--repo") {
+		t.Errorf("expected a missing --repo error, got %d: %s", status, stderr.String())
+	}
+	if status := run([]string{"refs", "--repo", "/nonexistent"}, &stdout, &stderr); status != 1 {
+		t.Errorf("expected a failure opening a missing repo, got %d", status)
+	}
+}
@@ -0,0 +1,273 @@
+// Package bls reads, writes and validates Boot Loader Specification entries,
+// such as the loader/entries/*.conf files ostree writes for each deployment
+package bls
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ostreedev/ostree-go/pkg/kargs"
+)
+
+// Field is a key/value line of an entry not covered by the Entry fields
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Entry is a single boot loader entry
+type Entry struct {
+	// Filename is the base name of the entry file, set when reading entries
+	// from disk and used by WriteDir
+	Filename string
+	// Title is the human readable name of the entry
+	Title string
+	// Version is the version of the entry, used to sort entries
+	Version string
+	// Linux is the path of the kernel, relative to the boot partition
+	Linux string
+	// Initrd lists the paths of the initramfs images, in load order
+	Initrd []string
+	// Options is the kernel command line
+	Options string
+	// Devicetree is the path of the device tree blob, if any
+	Devicetree string
+	// Extra holds all other lines of the entry, in order
+	Extra []Field
+}
+
+// Parse parses a boot loader entry.  Each line holds a key and a value
+// separated by whitespace; empty lines and lines starting with '#' are
+// ignored.
+func Parse(r io.Reader) (*Entry, error) {
+	e := &Entry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var key, value string
+		if sep := strings.IndexAny(line, " \t"); sep == -1 {
+			key = line
+		} else {
+			key = line[:sep]
+			value = strings.TrimLeft(line[sep:], " \t")
+		}
+
+		switch key {
+		case "title":
+			e.Title = value
+		case "version":
+			e.Version = value
+		case "linux":
+			e.Linux = value
+		case "initrd":
+			e.Initrd = append(e.Initrd, value)
+		case "options":
+			e.Options = value
+		case "devicetree":
+			e.Devicetree = value
+		default:
+			e.Extra = append(e.Extra, Field{key, value})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ReadFile parses the boot loader entry at path
+func ReadFile(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	e, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	e.Filename = filepath.Base(path)
+	return e, nil
+}
+
+// ReadDir parses all *.conf entries in dir, typically
+// /boot/loader/entries, and returns them sorted like ostree does
+func ReadDir(dir string) ([]*Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(paths))
+	for _, path := range paths {
+		e, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	Sort(entries)
+	return entries, nil
+}
+
+// Sort sorts entries the way ostree orders deployments: entries with a
+// version come first, highest version first, compared with the semantics
+// of strverscmp(3)
+func Sort(entries []*Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Version, entries[j].Version
+		switch {
+		case a != "" && b != "":
+			return strverscmp(a, b) > 0
+		case a != "":
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+// Kargs returns the parsed kernel command line of the entry
+func (e *Entry) Kargs() *kargs.Kargs {
+	return kargs.Parse(e.Options)
+}
+
+// Validate checks that the entry is well formed: a kernel is set, all paths
+// are absolute and no key or value spans multiple lines
+func (e *Entry) Validate() error {
+	if e.Linux == "" {
+		return errors.New("missing linux key")
+	}
+
+	paths := append([]string{e.Linux}, e.Initrd...)
+	if e.Devicetree != "" {
+		paths = append(paths, e.Devicetree)
+	}
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q is not absolute", path)
+		}
+	}
+
+	for _, f := range e.fields() {
+		if f.Key == "" || strings.ContainsAny(f.Key, " \t\r\n") {
+			return fmt.Errorf("invalid key %q", f.Key)
+		}
+		if strings.ContainsAny(f.Value, "\r\n") {
+			return fmt.Errorf("value of %s spans multiple lines", f.Key)
+		}
+	}
+	return nil
+}
+
+// ValidateFiles checks that the kernel, initramfs and device tree files
+// referenced by the entry exist in bootDir, the mount point of the boot
+// partition (e.g. /boot)
+func (e *Entry) ValidateFiles(bootDir string) error {
+	paths := append([]string{e.Linux}, e.Initrd...)
+	if e.Devicetree != "" {
+		paths = append(paths, e.Devicetree)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.Join(bootDir, path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fields returns all lines of the entry, in the order they are written
+func (e *Entry) fields() []Field {
+	var fields []Field
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, Field{key, value})
+		}
+	}
+
+	add("title", e.Title)
+	add("version", e.Version)
+	add("options", e.Options)
+	add("linux", e.Linux)
+	for _, initrd := range e.Initrd {
+		add("initrd", initrd)
+	}
+	add("devicetree", e.Devicetree)
+	return append(fields, e.Extra...)
+}
+
+// Write validates the entry and writes it to w
+func (e *Entry) Write(w io.Writer) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range e.fields() {
+		line := f.Key
+		if f.Value != "" {
+			line += " " + f.Value
+		}
+		if _, err := fmt.Fprintln(bw, line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteFile validates the entry and atomically writes it to path
+func (e *Entry) WriteFile(path string) error {
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteDir writes each entry to dir under its Filename
+func WriteDir(dir string, entries []*Entry) error {
+	for _, e := range entries {
+		if e.Filename == "" || strings.ContainsRune(e.Filename, '/') {
+			return fmt.Errorf("invalid entry filename %q", e.Filename)
+		}
+		if err := e.WriteFile(filepath.Join(dir, e.Filename)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
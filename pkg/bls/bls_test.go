@@ -0,0 +1,211 @@
+package bls
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ostreeEntry = `# Written by ostree
+title Fedora CoreOS 36 (ostree:0)
+version 2
+options  root=UUID=1234 rw ostree=/ostree/boot.1/fedora-coreos/abcd/0
+linux /ostree/fedora-coreos-abcd/vmlinuz-5.18.5
+initrd /ostree/fedora-coreos-abcd/initramfs-5.18.5.img
+initrd /ostree/fedora-coreos-abcd/microcode.img
+aboot /ostree/deploy/fedora-coreos/deploy/1234.0/usr/lib/ostree-boot/aboot.img
+`
+
+func TestParse(t *testing.T) {
+	e, err := Parse(strings.NewReader(ostreeEntry))
+	if err != nil {
+		t.Fatalf("failed to parse entry: %s", err)
+	}
+
+	expected := &Entry{
+		Title:   "Fedora CoreOS 36 (ostree:0)",
+		Version: "2",
+		Options: "root=UUID=1234 rw ostree=/ostree/boot.1/fedora-coreos/abcd/0",
+		Linux:   "/ostree/fedora-coreos-abcd/vmlinuz-5.18.5",
+		Initrd: []string{
+			"/ostree/fedora-coreos-abcd/initramfs-5.18.5.img",
+			"/ostree/fedora-coreos-abcd/microcode.img",
+		},
+		Extra: []Field{
+			{"aboot", "/ostree/deploy/fedora-coreos/deploy/1234.0/usr/lib/ostree-boot/aboot.img"},
+		},
+	}
+	if !reflect.DeepEqual(e, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, e)
+	}
+	if got := e.Kargs().Get("ostree"); len(got) != 1 || got[0] != "/ostree/boot.1/fedora-coreos/abcd/0" {
+		t.Errorf("unexpected ostree karg %q", got)
+	}
+	if err := e.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %s", err)
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	e, err := Parse(strings.NewReader(ostreeEntry))
+	if err != nil {
+		t.Fatalf("failed to parse entry: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		t.Fatalf("failed to write entry: %s", err)
+	}
+	if strings.Contains(buf.String(), "#") {
+		t.Errorf("unexpected comment in output %q", buf.String())
+	}
+	if !strings.HasPrefix(buf.String(), "title Fedora CoreOS 36 (ostree:0)\nversion 2\n") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	reparsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("failed to parse written entry: %s", err)
+	}
+	if !reflect.DeepEqual(e, reparsed) {
+		t.Fatalf("expected %+v, got %+v", e, reparsed)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		entry Entry
+		isErr bool
+	}{
+		{
+			Entry{Linux: "/vmlinuz", Initrd: []string{"/initramfs.img"}, Devicetree: "/dtb"},
+			false,
+		},
+		{
+			Entry{Title: "no kernel"},
+			true,
+		},
+		{
+			Entry{Linux: "vmlinuz"},
+			true,
+		},
+		{
+			Entry{Linux: "/vmlinuz", Initrd: []string{"initramfs.img"}},
+			true,
+		},
+		{
+			Entry{Linux: "/vmlinuz", Options: "quiet\nrw"},
+			true,
+		},
+		{
+			Entry{Linux: "/vmlinuz", Extra: []Field{{"bad key", "value"}}},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		err := tt.entry.Validate()
+		if tt.isErr && err == nil {
+			t.Errorf("%+v: got unexpected nil error", tt.entry)
+		} else if !tt.isErr && err != nil {
+			t.Errorf("%+v: got unexpected error %q", tt.entry, err)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	entries := []*Entry{
+		{Filename: "noversion.conf"},
+		{Filename: "ostree-1.conf", Version: "1"},
+		{Filename: "ostree-10.conf", Version: "10"},
+		{Filename: "ostree-9.conf", Version: "9"},
+		{Filename: "ostree-2.conf", Version: "2"},
+	}
+	Sort(entries)
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Filename)
+	}
+	expected := []string{"ostree-10.conf", "ostree-9.conf", "ostree-2.conf", "ostree-1.conf", "noversion.conf"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestStrverscmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		sign int
+	}{
+		{"1", "1", 0},
+		{"9", "10", -1},
+		{"a2", "a10", -1},
+		{"1.10", "1.9", 1},
+		{"000", "00", -1},
+		{"00", "01", -1},
+		{"01", "010", -1},
+		{"010", "09", -1},
+		{"09", "0", -1},
+		{"0", "1", -1},
+		{"abc", "abd", -1},
+		{"5.18.5-200", "5.18.5-100", 1},
+	}
+
+	for _, tt := range tests {
+		got := strverscmp(tt.a, tt.b)
+		if (got < 0 && tt.sign >= 0) || (got > 0 && tt.sign <= 0) || (got == 0 && tt.sign != 0) {
+			t.Errorf("strverscmp(%q, %q) = %d, expected sign %d", tt.a, tt.b, got, tt.sign)
+		}
+	}
+}
+
+func TestReadWriteDir(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "bls-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	entries := []*Entry{
+		{Filename: "ostree-1-os.conf", Title: "old", Version: "1", Linux: "/ostree/os-a/vmlinuz", Options: "rw"},
+		{Filename: "ostree-2-os.conf", Title: "new", Version: "2", Linux: "/ostree/os-b/vmlinuz", Options: "rw quiet"},
+	}
+	if err := WriteDir(baseDir, entries); err != nil {
+		t.Fatalf("failed to write entries: %s", err)
+	}
+	if err := WriteDir(baseDir, []*Entry{{Linux: "/vmlinuz"}}); err == nil {
+		t.Fatal("got unexpected nil error for entry without filename")
+	}
+
+	read, err := ReadDir(baseDir)
+	if err != nil {
+		t.Fatalf("failed to read entries: %s", err)
+	}
+	if len(read) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(read))
+	}
+	if read[0].Title != "new" || read[1].Title != "old" {
+		t.Errorf("unexpected entry order %q, %q", read[0].Title, read[1].Title)
+	}
+
+	// Only the kernel of the first entry exists on the boot partition
+	kernelDir := path.Join(baseDir, "ostree", "os-b")
+	if err := os.MkdirAll(kernelDir, 0755); err != nil {
+		t.Fatalf("failed to create kernel dir: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(kernelDir, "vmlinuz"), []byte("kernel"), 0644); err != nil {
+		t.Fatalf("failed to write kernel: %s", err)
+	}
+	if err := read[0].ValidateFiles(baseDir); err != nil {
+		t.Errorf("unexpected error validating files: %s", err)
+	}
+	if err := read[1].ValidateFiles(baseDir); err == nil {
+		t.Error("got unexpected nil error for missing kernel")
+	}
+}
@@ -0,0 +1,99 @@
+package bls
+
+// States of the strverscmp automaton: normal characters, integral part,
+// fractional part, and leading zeroes
+const (
+	stateN = 0
+	stateI = 3
+	stateF = 6
+	stateZ = 9
+)
+
+// Results of the strverscmp automaton besides -1 and +1: compare the
+// differing characters, or compare the lengths of the digit runs
+const (
+	resultCmp = 2
+	resultLen = 3
+)
+
+var strverscmpNextState = [...]int{
+	/* state    x       d       0 */
+	/* S_N */ stateN, stateI, stateZ,
+	/* S_I */ stateN, stateI, stateI,
+	/* S_F */ stateN, stateF, stateF,
+	/* S_Z */ stateN, stateF, stateZ,
+}
+
+var strverscmpResultType = [...]int{
+	/* state   x/x        x/d        x/0        d/x        d/d        d/0        0/x        0/d        0/0 */
+	/* S_N */ resultCmp, resultCmp, resultCmp, resultCmp, resultLen, resultCmp, resultCmp, resultCmp, resultCmp,
+	/* S_I */ resultCmp, -1, -1, +1, resultLen, resultLen, +1, resultLen, resultLen,
+	/* S_F */ resultCmp, resultCmp, resultCmp, resultCmp, resultCmp, resultCmp, resultCmp, resultCmp, resultCmp,
+	/* S_Z */ resultCmp, +1, +1, -1, resultCmp, resultCmp, -1, resultCmp, resultCmp,
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// charClass returns 0 for non-digits, 1 for non-zero digits and 2 for '0'
+func charClass(c byte) int {
+	class := 0
+	if c == '0' {
+		class++
+	}
+	if isDigit(c) {
+		class++
+	}
+	return class
+}
+
+// strverscmp is a port of the glibc function of the same name, which ostree
+// uses to order boot loader entries: digit sequences are compared
+// numerically, so that "10" sorts after "9".  It returns a negative, zero or
+// positive value like strcmp(3).
+func strverscmp(s1, s2 string) int {
+	// at returns the byte at i, or the terminating NUL past the end
+	at := func(s string, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+
+	i := 0
+	c1, c2 := at(s1, i), at(s2, i)
+	state := stateN + charClass(c1)
+
+	diff := int(c1) - int(c2)
+	for diff == 0 {
+		if c1 == 0 {
+			return 0
+		}
+		state = strverscmpNextState[state]
+		i++
+		c1, c2 = at(s1, i), at(s2, i)
+		state += charClass(c1)
+		diff = int(c1) - int(c2)
+	}
+
+	switch result := strverscmpResultType[state*3+charClass(c2)]; result {
+	case resultCmp:
+		return diff
+	case resultLen:
+		for j := i + 1; ; j++ {
+			if !isDigit(at(s1, j)) {
+				if isDigit(at(s2, j)) {
+					return -1
+				}
+				return diff
+			}
+			if !isDigit(at(s2, j)) {
+				return 1
+			}
+		}
+	default:
+		return result
+	}
+}
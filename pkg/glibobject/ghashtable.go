@@ -19,6 +19,7 @@
 package glibobject
 
 import (
+	"runtime"
 	"unsafe"
 )
 
@@ -37,6 +38,15 @@ type GHashTable struct {
 	ptr unsafe.Pointer
 }
 
+// GHashTableNew wraps p, taking ownership of the caller's reference.  The
+// reference is dropped when the returned GHashTable is garbage collected, so
+// Unref must not be called on it.
+func GHashTableNew(p unsafe.Pointer) *GHashTable {
+	ht := &GHashTable{p}
+	runtime.SetFinalizer(ht, (*GHashTable).Unref)
+	return ht
+}
+
 func (ht *GHashTable) Ptr() unsafe.Pointer {
 	return ht.ptr
 }
@@ -45,6 +55,14 @@ func (ht *GHashTable) native() *C.GHashTable {
 	return (*C.GHashTable)(ht.ptr)
 }
 
+func (ht *GHashTable) Ref() {
+	C.g_hash_table_ref(ht.native())
+}
+
+func (ht *GHashTable) Unref() {
+	C.g_hash_table_unref(ht.native())
+}
+
 func ToGHashTable(ptr unsafe.Pointer) *GHashTable {
 	return &GHashTable{ptr}
 }
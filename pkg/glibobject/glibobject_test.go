@@ -0,0 +1,36 @@
+package glibobject
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGVariantNewSink(t *testing.T) {
+	for _, floating := range []bool{true, false} {
+		freed := testVariantsFreed()
+		v := GVariantNewSink(newTestVariant(floating))
+		if v.IsFloating() {
+			t.Errorf("floating %v: variant still floating after GVariantNewSink", floating)
+		}
+
+		// Drop the only reference the GVariant should hold
+		runtime.SetFinalizer(v, nil)
+		v.Unref()
+		if testVariantsFreed() != freed+1 {
+			t.Errorf("floating %v: variant leaked by GVariantNewSink", floating)
+		}
+	}
+}
+
+func TestGObjectNewSink(t *testing.T) {
+	for _, floating := range []bool{true, false} {
+		o := GObjectNewSink(newTestObject(floating))
+		if o.IsFloating() {
+			t.Errorf("floating %v: object still floating after GObjectNewSink", floating)
+		}
+		if count := objectRefCount(o.Ptr()); count != 1 {
+			t.Errorf("floating %v: object has %d references after GObjectNewSink, expected 1", floating, count)
+		}
+		runtime.KeepAlive(o)
+	}
+}
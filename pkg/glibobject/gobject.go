@@ -26,6 +26,7 @@ package glibobject
 // #include <stdlib.h>
 import "C"
 import (
+	"runtime"
 	"unsafe"
 )
 
@@ -46,6 +47,25 @@ type GObject struct {
 	ptr unsafe.Pointer
 }
 
+// GObjectNew wraps p, taking ownership of the caller's reference.  The
+// reference is dropped when the returned GObject is garbage collected, so
+// Unref must not be called on it.
+func GObjectNew(p unsafe.Pointer) *GObject {
+	o := &GObject{p}
+	runtime.SetFinalizer(o, (*GObject).Unref)
+	return o
+}
+
+// GObjectNewSink wraps p like GObjectNew, first sinking the reference if it
+// is floating
+func GObjectNewSink(p unsafe.Pointer) *GObject {
+	o := GObjectNew(p)
+	if o.IsFloating() {
+		o.RefSink()
+	}
+	return o
+}
+
 func (v *GObject) Ptr() unsafe.Pointer {
 	return v.ptr
 }
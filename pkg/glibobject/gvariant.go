@@ -27,6 +27,7 @@ package glibobject
 import "C"
 import (
 	"fmt"
+	"runtime"
 	"unsafe"
 )
 
@@ -38,18 +39,24 @@ type GVariant struct {
 	ptr unsafe.Pointer
 }
 
-//func GVariantNew(p unsafe.Pointer) *GVariant {
-//o := &GVariant{p}
-//runtime.SetFinalizer(o, (*GVariant).Unref)
-//return o;
-//}
+// GVariantNew wraps p, taking ownership of the caller's reference.  The
+// reference is dropped when the returned GVariant is garbage collected, so
+// Unref must not be called on it.
+func GVariantNew(p unsafe.Pointer) *GVariant {
+	o := &GVariant{p}
+	runtime.SetFinalizer(o, (*GVariant).Unref)
+	return o
+}
 
-//func GVariantNewSink(p unsafe.Pointer) *GVariant {
-//o := &GVariant{p}
-//runtime.SetFinalizer(o, (*GVariant).Unref)
-//o.RefSink()
-//return o;
-//}
+// GVariantNewSink wraps p like GVariantNew, first sinking the reference if
+// it is floating
+func GVariantNewSink(p unsafe.Pointer) *GVariant {
+	o := GVariantNew(p)
+	if o.IsFloating() {
+		o.RefSink()
+	}
+	return o
+}
 
 func (v *GVariant) native() *C.GVariant {
 	return (*C.GVariant)(v.ptr)
@@ -74,6 +81,11 @@ func (v *GVariant) RefSink() {
 	C.g_variant_ref_sink(v.native())
 }
 
+func (v *GVariant) IsFloating() bool {
+	c := C.g_variant_is_floating(v.native())
+	return GoBool(GBoolean(c))
+}
+
 func (v *GVariant) TypeString() string {
 	cs := (*C.char)(C.g_variant_get_type_string(v.native()))
 	return C.GoString(cs)
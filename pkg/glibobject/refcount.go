@@ -0,0 +1,71 @@
+package glibobject
+
+// Constructors for the reference counting tests, which can't use cgo
+
+// #cgo pkg-config: glib-2.0 gobject-2.0
+// #include <glib.h>
+// #include <glib-object.h>
+//
+// static gint _test_variants_freed;
+//
+// static void
+// _test_variant_notify (gpointer data)
+// {
+//   g_atomic_int_inc (&_test_variants_freed);
+// }
+//
+// static GVariant *
+// _test_variant_new (void)
+// {
+//   static const guint32 value = 42;
+//   return g_variant_new_from_data (G_VARIANT_TYPE_UINT32, &value, sizeof value,
+//                                   TRUE, _test_variant_notify, NULL);
+// }
+//
+// static gint
+// _test_variants_freed_count (void)
+// {
+//   return g_atomic_int_get (&_test_variants_freed);
+// }
+//
+// static GObject *
+// _test_object_new (void)
+// {
+//   return g_object_new (G_TYPE_OBJECT, NULL);
+// }
+//
+// static guint
+// _test_object_ref_count (gpointer object)
+// {
+//   return g_atomic_int_get ((gint *) &G_OBJECT (object)->ref_count);
+// }
+import "C"
+import "unsafe"
+
+// newTestVariant returns a new GVariant, floating or with a full reference
+func newTestVariant(floating bool) unsafe.Pointer {
+	v := C._test_variant_new()
+	if !floating {
+		C.g_variant_ref_sink(v)
+	}
+	return unsafe.Pointer(v)
+}
+
+// testVariantsFreed returns how many variants of newTestVariant were freed
+func testVariantsFreed() int {
+	return int(C._test_variants_freed_count())
+}
+
+// newTestObject returns a new GObject, floating or with a full reference
+func newTestObject(floating bool) unsafe.Pointer {
+	o := C._test_object_new()
+	if floating {
+		C.g_object_force_floating(o)
+	}
+	return unsafe.Pointer(o)
+}
+
+// objectRefCount returns the reference count of a GObject
+func objectRefCount(p unsafe.Pointer) int {
+	return int(C._test_object_ref_count(C.gpointer(p)))
+}
@@ -0,0 +1,245 @@
+// Package kargs parses, edits and serializes kernel command lines, following
+// the semantics of libostree's kernel argument handling
+package kargs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// karg is a single kernel argument, either a bare key or a key=value pair
+type karg struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// String serializes the argument as it appears on the command line
+func (a karg) String() string {
+	if !a.hasValue {
+		return a.key
+	}
+	return a.key + "=" + a.value
+}
+
+// Kargs is an ordered list of kernel arguments.  A key may appear several
+// times with different values (e.g. multiple console= arguments).  The zero
+// value is an empty list ready to use.
+type Kargs struct {
+	args []karg
+}
+
+// Parse parses a kernel command line, such as the options line of a boot
+// loader entry.  Arguments are separated by whitespace, except inside
+// double quotes.
+func Parse(options string) *Kargs {
+	k := &Kargs{}
+	for _, arg := range split(options) {
+		k.args = append(k.args, parseArg(arg))
+	}
+	return k
+}
+
+// split splits a command line on whitespace, keeping quoted sections intact
+func split(options string) []string {
+	var args []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range options {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// parseArg splits a single argument into its key and value
+func parseArg(arg string) karg {
+	eq := strings.IndexRune(arg, '=')
+	if eq == -1 {
+		return karg{key: arg}
+	}
+	return karg{key: arg[:eq], value: arg[eq+1:], hasValue: true}
+}
+
+// keyEqual compares two keys the way the kernel does, treating dashes and
+// underscores as equivalent
+func keyEqual(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if ca == '-' {
+			ca = '_'
+		}
+		if cb == '-' {
+			cb = '_'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+// indexes returns the positions of all arguments with the given key
+func (k *Kargs) indexes(key string) []int {
+	var indexes []int
+	for i, a := range k.args {
+		if keyEqual(a.key, key) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// String serializes the arguments into a command line
+func (k *Kargs) String() string {
+	return strings.Join(k.Strings(), " ")
+}
+
+// Strings returns the arguments as a list, in order
+func (k *Kargs) Strings() []string {
+	strs := make([]string, 0, len(k.args))
+	for _, a := range k.args {
+		strs = append(strs, a.String())
+	}
+	return strs
+}
+
+// Has returns whether any argument has the given key
+func (k *Kargs) Has(key string) bool {
+	return len(k.indexes(key)) > 0
+}
+
+// Contains returns whether the exact argument (key or key=value) is present
+func (k *Kargs) Contains(arg string) bool {
+	want := parseArg(arg)
+	for _, i := range k.indexes(want.key) {
+		if k.args[i].hasValue == want.hasValue && k.args[i].value == want.value {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns all values of the given key, in order.  Arguments without a
+// value yield an empty string.
+func (k *Kargs) Get(key string) []string {
+	var values []string
+	for _, i := range k.indexes(key) {
+		values = append(values, k.args[i].value)
+	}
+	return values
+}
+
+// Append adds an argument at the end, even if its key is already present
+func (k *Kargs) Append(arg string) {
+	k.args = append(k.args, parseArg(arg))
+}
+
+// AppendIfMissing adds an argument at the end unless its key is already
+// present, like `ostree admin kargs edit-in-place --append-if-missing`
+func (k *Kargs) AppendIfMissing(arg string) {
+	if !k.Has(parseArg(arg).key) {
+		k.Append(arg)
+	}
+}
+
+// Set replaces all values of the key of arg with arg, at the position of
+// the first one, or appends arg if the key is not present
+func (k *Kargs) Set(arg string) {
+	a := parseArg(arg)
+	indexes := k.indexes(a.key)
+	if len(indexes) == 0 {
+		k.args = append(k.args, a)
+		return
+	}
+	k.args[indexes[0]] = a
+	k.remove(indexes[1:])
+}
+
+// Replace changes the value of an existing key.  arg is either key=new,
+// which requires the key to have a single value, or key=old=new, which
+// replaces only the value old.
+func (k *Kargs) Replace(arg string) error {
+	a := parseArg(arg)
+	indexes := k.indexes(a.key)
+	if len(indexes) == 0 {
+		return fmt.Errorf("no key '%s' found", a.key)
+	}
+
+	if eq := strings.IndexRune(a.value, '='); eq != -1 {
+		oldValue, newValue := a.value[:eq], a.value[eq+1:]
+		for _, i := range indexes {
+			if k.args[i].hasValue && k.args[i].value == oldValue {
+				k.args[i].value = newValue
+				return nil
+			}
+		}
+		return fmt.Errorf("no karg '%s=%s' found", a.key, oldValue)
+	}
+
+	if len(indexes) > 1 {
+		return fmt.Errorf("multiple values for key '%s' found", a.key)
+	}
+	k.args[indexes[0]] = a
+	return nil
+}
+
+// Delete removes an argument.  arg is either key=value, which removes that
+// exact argument, or a bare key, which requires the key to have a single
+// value.
+func (k *Kargs) Delete(arg string) error {
+	a := parseArg(arg)
+	indexes := k.indexes(a.key)
+	if len(indexes) == 0 {
+		return fmt.Errorf("no key '%s' found", a.key)
+	}
+
+	if !a.hasValue {
+		if len(indexes) > 1 {
+			return fmt.Errorf("multiple values for key '%s' found", a.key)
+		}
+		k.remove(indexes)
+		return nil
+	}
+
+	for _, i := range indexes {
+		if k.args[i].hasValue && k.args[i].value == a.value {
+			k.remove([]int{i})
+			return nil
+		}
+	}
+	return fmt.Errorf("no karg '%s' found", arg)
+}
+
+// DeleteIfPresent is like Delete, but does nothing if the key is not
+// present, like `ostree admin kargs edit-in-place --delete-if-present`
+func (k *Kargs) DeleteIfPresent(arg string) error {
+	if !k.Has(parseArg(arg).key) {
+		return nil
+	}
+	return k.Delete(arg)
+}
+
+// remove drops the arguments at the given ascending positions
+func (k *Kargs) remove(indexes []int) {
+	for n := len(indexes) - 1; n >= 0; n-- {
+		i := indexes[n]
+		k.args = append(k.args[:i], k.args[i+1:]...)
+	}
+}
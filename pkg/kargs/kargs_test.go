@@ -0,0 +1,215 @@
+package kargs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		out  []string
+		line string
+	}{
+		{
+			"",
+			[]string{},
+			"",
+		},
+		{
+			"root=/dev/vda1 quiet  rw",
+			[]string{"root=/dev/vda1", "quiet", "rw"},
+			"root=/dev/vda1 quiet rw",
+		},
+		{
+			"console=tty0 console=ttyS0,115200n8\n",
+			[]string{"console=tty0", "console=ttyS0,115200n8"},
+			"console=tty0 console=ttyS0,115200n8",
+		},
+		{
+			`init="/bin/sh -c true" empty= dyndbg="file foo.c +p"`,
+			[]string{`init="/bin/sh -c true"`, "empty=", `dyndbg="file foo.c +p"`},
+			`init="/bin/sh -c true" empty= dyndbg="file foo.c +p"`,
+		},
+	}
+
+	for _, tt := range tests {
+		k := Parse(tt.in)
+		if got := k.Strings(); !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("Parse(%q): expected %q, got %q", tt.in, tt.out, got)
+		}
+		if got := k.String(); got != tt.line {
+			t.Errorf("Parse(%q).String(): expected %q, got %q", tt.in, tt.line, got)
+		}
+	}
+}
+
+func TestGetAndContains(t *testing.T) {
+	k := Parse("console=tty0 quiet console=ttyS0 rd.lvm-lv=a empty=")
+
+	if got := k.Get("console"); !reflect.DeepEqual(got, []string{"tty0", "ttyS0"}) {
+		t.Errorf("unexpected console values %q", got)
+	}
+	if got := k.Get("quiet"); !reflect.DeepEqual(got, []string{""}) {
+		t.Errorf("unexpected quiet values %q", got)
+	}
+	if got := k.Get("missing"); got != nil {
+		t.Errorf("unexpected missing values %q", got)
+	}
+	if !k.Has("rd.lvm_lv") {
+		t.Error("expected dashes and underscores to be equivalent in keys")
+	}
+	if !k.Contains("console=ttyS0") || k.Contains("console=ttyS1") {
+		t.Error("unexpected Contains result for console")
+	}
+	if !k.Contains("quiet") || k.Contains("quiet=") {
+		t.Error("expected bare keys and empty values to be distinct")
+	}
+	if !k.Contains("empty=") || k.Contains("empty") {
+		t.Error("expected empty values and bare keys to be distinct")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		edit  func(k *Kargs) error
+		out   string
+		isErr bool
+	}{
+		{
+			"append multi-valued",
+			"console=tty0 quiet",
+			func(k *Kargs) error { k.Append("console=ttyS0"); return nil },
+			"console=tty0 quiet console=ttyS0",
+			false,
+		},
+		{
+			"append if missing present",
+			"console=tty0 quiet",
+			func(k *Kargs) error { k.AppendIfMissing("console=ttyS0"); return nil },
+			"console=tty0 quiet",
+			false,
+		},
+		{
+			"append if missing absent",
+			"console=tty0",
+			func(k *Kargs) error { k.AppendIfMissing("quiet"); return nil },
+			"console=tty0 quiet",
+			false,
+		},
+		{
+			"set collapses values",
+			"console=tty0 quiet console=ttyS0",
+			func(k *Kargs) error { k.Set("console=hvc0"); return nil },
+			"console=hvc0 quiet",
+			false,
+		},
+		{
+			"replace single value",
+			"root=/dev/vda1 quiet",
+			func(k *Kargs) error { return k.Replace("root=/dev/vda2") },
+			"root=/dev/vda2 quiet",
+			false,
+		},
+		{
+			"replace specific value",
+			"console=tty0 console=ttyS0",
+			func(k *Kargs) error { return k.Replace("console=ttyS0=ttyS1") },
+			"console=tty0 console=ttyS1",
+			false,
+		},
+		{
+			"replace ambiguous",
+			"console=tty0 console=ttyS0",
+			func(k *Kargs) error { return k.Replace("console=hvc0") },
+			"console=tty0 console=ttyS0",
+			true,
+		},
+		{
+			"replace missing key",
+			"quiet",
+			func(k *Kargs) error { return k.Replace("root=/dev/vda1") },
+			"quiet",
+			true,
+		},
+		{
+			"replace missing value",
+			"console=tty0",
+			func(k *Kargs) error { return k.Replace("console=ttyS0=ttyS1") },
+			"console=tty0",
+			true,
+		},
+		{
+			"delete bare key",
+			"root=/dev/vda1 quiet rw",
+			func(k *Kargs) error { return k.Delete("quiet") },
+			"root=/dev/vda1 rw",
+			false,
+		},
+		{
+			"delete single valued key",
+			"root=/dev/vda1 quiet",
+			func(k *Kargs) error { return k.Delete("root") },
+			"quiet",
+			false,
+		},
+		{
+			"delete specific value",
+			"console=tty0 quiet console=ttyS0",
+			func(k *Kargs) error { return k.Delete("console=tty0") },
+			"quiet console=ttyS0",
+			false,
+		},
+		{
+			"delete ambiguous",
+			"console=tty0 console=ttyS0",
+			func(k *Kargs) error { return k.Delete("console") },
+			"console=tty0 console=ttyS0",
+			true,
+		},
+		{
+			"delete missing",
+			"quiet",
+			func(k *Kargs) error { return k.Delete("rw") },
+			"quiet",
+			true,
+		},
+		{
+			"delete if present absent",
+			"quiet",
+			func(k *Kargs) error { return k.DeleteIfPresent("rw") },
+			"quiet",
+			false,
+		},
+		{
+			"delete quoted value",
+			`init="/bin/sh -c true" quiet`,
+			func(k *Kargs) error { return k.Delete(`init="/bin/sh -c true"`) },
+			"quiet",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		k := Parse(tt.in)
+		err := tt.edit(k)
+		if tt.isErr && err == nil {
+			t.Errorf("%s: got unexpected nil error", tt.name)
+		} else if !tt.isErr && err != nil {
+			t.Errorf("%s: got unexpected error %q", tt.name, err)
+		}
+		if got := k.String(); got != tt.out {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.out, got)
+		}
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var k Kargs
+	k.Append("quiet")
+	if k.String() != "quiet" {
+		t.Errorf("unexpected command line %q", k.String())
+	}
+}
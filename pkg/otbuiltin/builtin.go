@@ -5,7 +5,9 @@ package otbuiltin
 import (
 	"errors"
 	"fmt"
+	"os"
 	"runtime"
+	"sync"
 	"unsafe"
 
 	glib "github.com/ostreedev/ostree-go/pkg/glibobject"
@@ -21,6 +23,14 @@ import "C"
 // Repo represents a local ostree repository
 type Repo struct {
 	ptr unsafe.Pointer
+	// tempDir is the directory removed by Close, for repos created by NewTempRepo
+	tempDir string
+
+	// mu guards txn, the transaction started by BeginTransaction, and
+	// prepared, set while one started by PrepareTransaction is in progress
+	mu       sync.Mutex
+	txn      *Transaction
+	prepared bool
 }
 
 // isInitialized checks if the repo has been initialized
@@ -44,7 +54,7 @@ func repoFromNative(or *C.OstreeRepo) *Repo {
 	if or == nil {
 		return nil
 	}
-	r := &Repo{unsafe.Pointer(or)}
+	r := &Repo{ptr: unsafe.Pointer(or)}
 	return r
 }
 
@@ -59,17 +69,49 @@ func OpenRepo(path string) (*Repo, error) {
 	repoPath := C.g_file_new_for_path(cpath)
 	defer C.g_object_unref(C.gpointer(repoPath))
 	crepo := C.ostree_repo_new(repoPath)
-	repo := repoFromNative(crepo)
 
 	var cerr *C.GError
 	r := glib.GoBool(glib.GBoolean(C.ostree_repo_open(crepo, nil, &cerr)))
 	if !r {
+		C.g_object_unref(C.gpointer(crepo))
 		return nil, generateError(cerr)
 	}
 
+	repo := repoFromNative(crepo)
+	runtime.SetFinalizer(repo, (*Repo).Close)
 	return repo, nil
 }
 
+// Close rolls back the transaction started by BeginTransaction, if any, and
+// releases the repo.  The repo must not be used afterwards.  Close is
+// called automatically when an opened repo is garbage collected, but
+// long-running programs should call it explicitly.
+func (r *Repo) Close() error {
+	if !r.isInitialized() {
+		return nil
+	}
+
+	r.mu.Lock()
+	txn := r.txn
+	r.mu.Unlock()
+	var err error
+	if txn != nil {
+		err = txn.Rollback()
+	}
+
+	runtime.SetFinalizer(r, nil)
+	C.g_object_unref(C.gpointer(r.ptr))
+	r.ptr = nil
+
+	if r.tempDir != "" {
+		if rmErr := os.RemoveAll(r.tempDir); err == nil {
+			err = rmErr
+		}
+		r.tempDir = ""
+	}
+	return err
+}
+
 // enableTombstoneCommits enables support for tombstone commits.
 //
 // This allows to distinguish between intentional deletions and accidental removals
@@ -79,21 +121,14 @@ func (r *Repo) enableTombstoneCommits() error {
 		return errors.New("repo not initialized")
 	}
 
-	config := C.ostree_repo_get_config(r.native())
-	groupC := C.CString("core")
-	defer C.free(unsafe.Pointer(groupC))
-	keyC := C.CString("tombstone-commits")
-	defer C.free(unsafe.Pointer(keyC))
-	valueC := C.g_key_file_get_boolean(config, (*C.gchar)(groupC), (*C.gchar)(keyC), nil)
-	tombstoneCommits := glib.GoBool(glib.GBoolean(valueC))
-
-	// tombstoneCommits is false only if it really is false or if it is set to FALSE in the config file
-	if !tombstoneCommits {
-		var cerr *C.GError
-		C.g_key_file_set_boolean(config, (*C.gchar)(groupC), (*C.gchar)(keyC), C.TRUE)
-		if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_config(r.native(), config, &cerr))) {
-			return generateError(cerr)
-		}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	// TombstoneCommits is false only if it really is false or if it is set to FALSE in the config file
+	if !cfg.TombstoneCommits {
+		return r.ConfigSet("core", "tombstone-commits", "true")
 	}
 	return nil
 }
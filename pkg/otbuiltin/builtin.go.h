@@ -5,22 +5,13 @@
 #include <ostree.h>
 #include <string.h>
 #include <fcntl.h>
-
-static guint32 owner_uid;
-static guint32 owner_gid;
+#include <stdint.h>
 
 static void
 _ostree_repo_append_modifier_flags(OstreeRepoCommitModifierFlags *flags, int flag) {
   *flags |= flag;
 }
 
-struct CommitFilterData {
-  GHashTable *mode_adds;
-  GHashTable *skip_list;
-};
-
-typedef struct CommitFilterData CommitFilterData;
-
 static char* _gptr_to_str(gpointer p)
 {
     return (char*)p;
@@ -33,12 +24,6 @@ _ostree_repo_file(GFile *file)
   return OSTREE_REPO_FILE (file);
 }
 
-static gpointer
-_guint_to_pointer (guint u)
-{
-  return GUINT_TO_POINTER (u);
-}
-
 static const GVariantType*
 _g_variant_type (char *type)
 {
@@ -60,21 +45,6 @@ _guint64_from_be (guint64 val)
 
 
 // These functions are wrappers for variadic functions since CGO can't parse variadic functions
-static void
-_g_printerr_onearg (char* msg,
-                    char* arg)
-{
-  g_printerr("%s %s\n", msg, arg);
-}
-
-static void
-_g_set_error_onearg (GError *err,
-                     char*  msg,
-                     char*  arg)
-{
-  g_set_error(&err, G_IO_ERROR, G_IO_ERROR_FAILED, "%s %s", msg, arg);
-}
-
 static void
 _g_variant_builder_add_twoargs (GVariantBuilder*     builder,
                                 const char    *format_string,
@@ -84,12 +54,6 @@ _g_variant_builder_add_twoargs (GVariantBuilder*     builder,
   g_variant_builder_add(builder, format_string, arg1, arg2);
 }
 
-static GHashTable*
-_g_hash_table_new_full ()
-{
-  return g_hash_table_new_full(g_str_hash, g_str_equal, g_free, NULL);
-}
-
 static void
 _g_variant_get_commit_dump (GVariant    *variant,
                             const char  *format,
@@ -118,62 +82,51 @@ _cleanup (OstreeRepo                *self,
     ostree_repo_commit_modifier_unref (modifier);
 }
 
-// The following functions make up a commit_filter function that gets passed into
-// another C function (and thus can't be a go function) as well as its helpers
+// Commit filter calling back into Go, exported from commitfilter.go.  The
+// user data is the cgo.Handle of the Go filter state.
+extern OstreeRepoCommitFilterResult goCommitFilter (OstreeRepo *repo,
+                                                    char       *path,
+                                                    GFileInfo  *file_info,
+                                                    gpointer    user_data);
+
 static OstreeRepoCommitFilterResult
-_commit_filter (OstreeRepo         *self,
-               const char         *path,
-               GFileInfo          *file_info,
-               gpointer            user_data)
+_go_commit_filter (OstreeRepo         *repo,
+                   const char         *path,
+                   GFileInfo          *file_info,
+                   gpointer            user_data)
 {
-  struct CommitFilterData *data = user_data;
-  GHashTable *mode_adds = data->mode_adds;
-  GHashTable *skip_list = data->skip_list;
-  gpointer value;
-
-  if (owner_uid >= 0)
-    g_file_info_set_attribute_uint32 (file_info, "unix::uid", owner_uid);
-  if (owner_gid >= 0)
-    g_file_info_set_attribute_uint32 (file_info, "unix::gid", owner_gid);
-
-  if (mode_adds && g_hash_table_lookup_extended (mode_adds, path, NULL, &value))
-    {
-      guint current_mode = g_file_info_get_attribute_uint32 (file_info, "unix::mode");
-      guint mode_add = GPOINTER_TO_UINT (value);
-      g_file_info_set_attribute_uint32 (file_info, "unix::mode",
-                                        current_mode | mode_add);
-      g_hash_table_remove (mode_adds, path);
-    }
-
-  if (skip_list && g_hash_table_contains (skip_list, path))
-    {
-      g_hash_table_remove (skip_list, path);
-      return OSTREE_REPO_COMMIT_FILTER_SKIP;
-    }
-
-  return OSTREE_REPO_COMMIT_FILTER_ALLOW;
+  return goCommitFilter (repo, (char *) path, file_info, user_data);
 }
 
+// Xattr callback calling back into Go, exported from commitfilter.go
+extern GVariant *goCommitXattrCallback (OstreeRepo *repo,
+                                        char       *path,
+                                        GFileInfo  *file_info,
+                                        gpointer    user_data);
 
-static void
-_set_owner_uid (guint32 uid)
+static GVariant *
+_go_commit_xattr_callback (OstreeRepo         *repo,
+                           const char         *path,
+                           GFileInfo          *file_info,
+                           gpointer            user_data)
 {
-  owner_uid = uid;
+  return goCommitXattrCallback (repo, (char *) path, file_info, user_data);
 }
 
-static void _set_owner_gid (guint32 gid)
+static void
+_ostree_repo_commit_modifier_set_xattr_callback_go (OstreeRepoCommitModifier *modifier,
+                                                    uintptr_t                 handle)
 {
-  owner_gid = gid;
+  ostree_repo_commit_modifier_set_xattr_callback (modifier, _go_commit_xattr_callback, NULL, (gpointer) handle);
 }
 
 // Wrapper function for a function that takes a C function as a parameter.
 // That translation doesn't work in go
 static OstreeRepoCommitModifier*
-_ostree_repo_commit_modifier_new_wrapper (OstreeRepoCommitModifierFlags  flags,
-                                          gpointer                       user_data,
-                                          GDestroyNotify                 destroy_notify)
+_ostree_repo_commit_modifier_new_go (OstreeRepoCommitModifierFlags  flags,
+                                     uintptr_t                      handle)
 {
-  return ostree_repo_commit_modifier_new(flags, _commit_filter, user_data, destroy_notify);
+  return ostree_repo_commit_modifier_new (flags, _go_commit_filter, (gpointer) handle, NULL);
 }
 
 #endif
@@ -0,0 +1,134 @@
+package otbuiltin
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// residentMemory returns the resident set size of the test process in bytes
+func residentMemory(t *testing.T) int64 {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		t.Skipf("cannot read memory usage: %s", err)
+	}
+	fields := strings.Fields(string(statm))
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %s", statm, err)
+	}
+	return pages * int64(os.Getpagesize())
+}
+
+// commitTestFS commits fsys as branch and returns the commit checksum
+func commitTestFS(t *testing.T, repo *Repo, fsys fs.FS, branch string) string {
+	opts := NewCommitOptions()
+	opts.Subject = branch
+	result, err := repo.CommitFS(fsys, branch, opts)
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+	return result.Checksum
+}
+
+func TestOpenCommitCloseLeak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping leak test in short mode")
+	}
+
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if _, err := InitRepo(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.Mkdir(commitDir, 0755); err != nil {
+		t.Fatalf("failed to make commit dir: %s", err)
+	}
+
+	const iterations = 2000
+	const warmup = 100
+	const ceiling = 64 << 20
+
+	var baseline int64
+	for i := 0; i < iterations; i++ {
+		if i == warmup {
+			runtime.GC()
+			baseline = residentMemory(t)
+		}
+
+		if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+		repo, err := OpenRepo(repoDir)
+		if err != nil {
+			t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+		}
+		tx, err := repo.BeginTransaction(context.Background())
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %s", err)
+		}
+		opts := NewCommitOptions()
+		opts.Subject = "leak test"
+		opts.AddMetadataString = []string{"iteration=" + strconv.Itoa(i)}
+		opts.AddDetachedMetadataString = []string{"iteration=" + strconv.Itoa(i)}
+		opts.Fsync = false
+		if _, err := repo.Commit(commitDir, "test-branch", opts); err != nil {
+			t.Fatalf("failed to commit: %s", err)
+		}
+		if _, err := tx.Commit(); err != nil {
+			t.Fatalf("failed to commit transaction: %s", err)
+		}
+		if err := repo.Close(); err != nil {
+			t.Fatalf("failed to close repo: %s", err)
+		}
+	}
+
+	runtime.GC()
+	if growth := residentMemory(t) - baseline; growth > ceiling {
+		t.Errorf("memory grew by %d bytes over %d iterations", growth, iterations-warmup)
+	}
+}
+
+func TestCloseRollsBack(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if _, err := InitRepo(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("failed to close repo: %s", err)
+	}
+	if err := tx.SetRef("", "test-branch", ""); err != ErrTransactionDone {
+		t.Errorf("expected ErrTransactionDone, got %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("unexpected error closing twice: %s", err)
+	}
+}
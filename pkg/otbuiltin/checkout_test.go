@@ -62,7 +62,7 @@ func TestCheckoutSuccessProcessOneBranch(t *testing.T) {
 	if err != nil {
 		t.Errorf("%s", err)
 	} else {
-		fmt.Println(ret)
+		fmt.Println(ret.Checksum)
 	}
 
 	_, err = repo.CommitTransaction()
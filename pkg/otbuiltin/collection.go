@@ -0,0 +1,151 @@
+package otbuiltin
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// CollectionRef identifies a ref globally: a ref name within the
+// collection of the repos sharing a collection ID
+type CollectionRef struct {
+	CollectionID string
+	RefName      string
+}
+
+// native converts a collection ref to a newly allocated C equivalent, to be
+// freed with ostree_collection_ref_free
+func (ref CollectionRef) native() *C.OstreeCollectionRef {
+	var ccollectionID *C.char
+	if ref.CollectionID != "" {
+		ccollectionID = C.CString(ref.CollectionID)
+		defer C.free(unsafe.Pointer(ccollectionID))
+	}
+	cref := C.CString(ref.RefName)
+	defer C.free(unsafe.Pointer(cref))
+	return C.ostree_collection_ref_new((*C.gchar)(ccollectionID), (*C.gchar)(cref))
+}
+
+// SetCollectionID sets the collection ID of the repo and writes it to the
+// repo's config.  An empty id clears it.
+func (r *Repo) SetCollectionID(id string) error {
+	if !r.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	var cid *C.char
+	if id != "" {
+		cid = C.CString(id)
+		defer C.free(unsafe.Pointer(cid))
+	}
+
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_set_collection_id(r.native(), (*C.gchar)(cid), &cerr)) {
+		return generateError(cerr)
+	}
+	config := C.ostree_repo_copy_config(r.native())
+	defer C.g_key_file_unref(config)
+	return r.writeConfig(config)
+}
+
+// CollectionID returns the collection ID of the repo, or an empty string
+// if it has none
+func (r *Repo) CollectionID() string {
+	if !r.isInitialized() {
+		return ""
+	}
+	defer runtime.KeepAlive(r)
+	return C.GoString((*C.char)(C.ostree_repo_get_collection_id(r.native())))
+}
+
+// ListCollectionRefs returns the checksums of all refs of the repo, local
+// and mirrored, keyed by collection ref.  If matchCollectionID is not
+// empty, only the refs of that collection are returned.
+func (r *Repo) ListCollectionRefs(matchCollectionID string) (map[CollectionRef]string, error) {
+	if !r.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	var cmatch *C.char
+	if matchCollectionID != "" {
+		cmatch = C.CString(matchCollectionID)
+		defer C.free(unsafe.Pointer(cmatch))
+	}
+
+	var cerr *C.GError
+	var crefs *C.GHashTable
+	if !isOk(C.ostree_repo_list_collection_refs(r.native(), cmatch, &crefs, C.OSTREE_REPO_LIST_REFS_EXT_NONE, nil, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	defer C.g_hash_table_unref(crefs)
+
+	refs := make(map[CollectionRef]string, int(C.g_hash_table_size(crefs)))
+	var iter C.GHashTableIter
+	var key, value C.gpointer
+	C.g_hash_table_iter_init(&iter, crefs)
+	for isOk(C.g_hash_table_iter_next(&iter, &key, &value)) {
+		cref := (*C.OstreeCollectionRef)(unsafe.Pointer(key))
+		ref := CollectionRef{
+			CollectionID: C.GoString((*C.char)(cref.collection_id)),
+			RefName:      C.GoString((*C.char)(cref.ref_name)),
+		}
+		refs[ref] = C.GoString(C._gptr_to_str(value))
+	}
+	return refs, nil
+}
+
+// ResolveCollectionRef returns the checksum ref points to, looking it up
+// among the refs of the repo's own collection and the mirrored ones
+func (r *Repo) ResolveCollectionRef(ref CollectionRef) (string, error) {
+	if !r.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	cref := ref.native()
+	defer C.ostree_collection_ref_free(cref)
+
+	var cerr *C.GError
+	var crev *C.char
+	if !isOk(C.ostree_repo_resolve_collection_ref(r.native(), cref, C.FALSE, C.OSTREE_REPO_RESOLVE_REV_EXT_NONE, &crev, nil, &cerr)) {
+		return "", generateError(cerr)
+	}
+	defer C.g_free(C.gpointer(crev))
+	return C.GoString(crev), nil
+}
+
+// addCollectionBinding returns commit metadata extended with the
+// ostree.collection-binding key, and ostree.ref-binding if branch is not
+// empty.  metadata may be nil; otherwise its reference is consumed.
+func addCollectionBinding(metadata *C.GVariant, collectionID, branch string) *C.GVariant {
+	dict := C.g_variant_dict_new(metadata)
+	defer C.g_variant_dict_unref(dict)
+	if metadata != nil {
+		C.g_variant_unref(metadata)
+	}
+
+	ckey := C.CString("ostree.collection-binding")
+	defer C.free(unsafe.Pointer(ckey))
+	ccollectionID := C.CString(collectionID)
+	defer C.free(unsafe.Pointer(ccollectionID))
+	C.g_variant_dict_insert_value(dict, (*C.gchar)(ckey), C.g_variant_new_string((*C.gchar)(ccollectionID)))
+
+	if branch != "" {
+		crefKey := C.CString("ostree.ref-binding")
+		defer C.free(unsafe.Pointer(crefKey))
+		cbranch := C.CString(branch)
+		defer C.free(unsafe.Pointer(cbranch))
+		C.g_variant_dict_insert_value(dict, (*C.gchar)(crefKey), C.g_variant_new_strv((**C.gchar)(unsafe.Pointer(&cbranch)), 1))
+	}
+
+	return C.g_variant_ref_sink(C.g_variant_dict_end(dict))
+}
@@ -0,0 +1,126 @@
+package otbuiltin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCollectionRefs(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.Mkdir(commitDir, 0755); err != nil {
+		t.Fatalf("failed to make commit dir: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	if repo.CollectionID() != "" {
+		t.Errorf("unexpected collection ID %q", repo.CollectionID())
+	}
+	if err := repo.SetCollectionID("org.example.Os"); err != nil {
+		t.Fatalf("failed to set collection ID: %s", err)
+	}
+	if repo.CollectionID() != "org.example.Os" {
+		t.Errorf("unexpected collection ID %q", repo.CollectionID())
+	}
+	if id, err := repo.ConfigGet("core", "collection-id"); err != nil || id != "org.example.Os" {
+		t.Errorf("expected collection ID in config, got %q: %v", id, err)
+	}
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	opts := NewCommitOptions()
+	opts.CollectionBinding = true
+	result, err := repo.Commit(commitDir, "os/x86_64", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	checksum := result.Checksum
+	if err := tx.SetCollectionRef("org.example.Other", "mirrored", checksum); err != nil {
+		t.Fatalf("failed to set collection ref: %s", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	refs, err := repo.ListCollectionRefs("")
+	if err != nil {
+		t.Fatalf("failed to list collection refs: %s", err)
+	}
+	expected := map[CollectionRef]string{
+		{"org.example.Os", "os/x86_64"}:   checksum,
+		{"org.example.Other", "mirrored"}: checksum,
+	}
+	if len(refs) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, refs)
+	}
+	for ref, rev := range expected {
+		if refs[ref] != rev {
+			t.Errorf("expected %v to point to %s, got %q", ref, rev, refs[ref])
+		}
+	}
+
+	refs, err = repo.ListCollectionRefs("org.example.Other")
+	if err != nil {
+		t.Fatalf("failed to list collection refs: %s", err)
+	}
+	if len(refs) != 1 {
+		t.Errorf("expected only the mirrored ref, got %v", refs)
+	}
+
+	rev, err := repo.ResolveCollectionRef(CollectionRef{"org.example.Other", "mirrored"})
+	if err != nil {
+		t.Fatalf("failed to resolve collection ref: %s", err)
+	}
+	if rev != checksum {
+		t.Errorf("expected %s, got %s", checksum, rev)
+	}
+	if _, err := repo.ResolveCollectionRef(CollectionRef{"org.example.Other", "missing"}); err == nil {
+		t.Error("got unexpected nil error resolving a missing ref")
+	}
+}
+
+func TestCollectionBindingWithoutID(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	opts := NewCommitOptions()
+	opts.CollectionBinding = true
+	if _, err := repo.Commit(baseDir, "os/x86_64", opts); err == nil {
+		t.Error("got unexpected nil error binding a commit without collection ID")
+	}
+}
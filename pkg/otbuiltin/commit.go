@@ -2,8 +2,11 @@ package otbuiltin
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"runtime"
+	"runtime/cgo"
 	"strings"
 	"time"
 	"unsafe"
@@ -21,34 +24,48 @@ import "C"
 // Declare global variable to store commitOptions
 var options commitOptions
 
-// Declare a function prototype for being passed into another function
-type handleLineFunc func(string, *glib.GHashTable) error
-
 // Contains all of the options for commmiting to an ostree repo.  Initialize
 // with NewCommitOptions()
 type commitOptions struct {
-	Subject                   string    // One line subject
-	Body                      string    // Full description
-	Parent                    string    // Parent of the commit
-	Tree                      []string  // 'dir=PATH' or 'tar=TARFILE' or 'ref=COMMIT': overlay the given argument as a tree
-	AddMetadataString         []string  // Add a key/value pair to metadata
-	AddDetachedMetadataString []string  // Add a key/value pair to detached metadata
-	OwnerUID                  int       // Set file ownership to user id
-	OwnerGID                  int       // Set file ownership to group id
-	NoXattrs                  bool      // Do not import extended attributes
-	LinkCheckoutSpeedup       bool      // Optimize for commits of trees composed of hardlinks in the repository
-	TarAutoCreateParents      bool      // When loading tar archives, automatically create parent directories as needed
-	SkipIfUnchanged           bool      // If the contents are unchanged from a previous commit, do nothing
-	StatOverrideFile          string    // File containing list of modifications to make permissions
-	SkipListFile              string    // File containing list of file paths to skip
-	GenerateSizes             bool      // Generate size information along with commit metadata
-	GpgSign                   []string  // GPG Key ID with which to sign the commit (if you have GPGME - GNU Privacy Guard Made Easy)
-	GpgHomedir                string    // GPG home directory to use when looking for keyrings (if you have GPGME - GNU Privacy Guard Made Easy)
-	Timestamp                 time.Time // Override the timestamp of the commit
-	Orphan                    bool      // Commit does not belong to a branch
-	Fsync                     bool      // Specify whether fsync should be used or not.  Default to true
+	Subject                   string         // One line subject
+	Body                      string         // Full description
+	Parent                    string         // Parent of the commit, or "none" for no parent; defaults to the commit of the branch
+	Tree                      []string       // 'dir=PATH' or 'tar=TARFILE' or 'ref=COMMIT': overlay the given argument as a tree
+	AddMetadataString         []string       // Add a key/value pair to metadata
+	AddDetachedMetadataString []string       // Add a key/value pair to detached metadata
+	OwnerUID                  int            // Set file ownership to user id
+	OwnerGID                  int            // Set file ownership to group id
+	NoXattrs                  bool           // Do not import extended attributes
+	LinkCheckoutSpeedup       bool           // Optimize for commits of trees composed of hardlinks in the repository
+	TarAutoCreateParents      bool           // When loading tar archives, automatically create parent directories as needed
+	SkipIfUnchanged           bool           // If the contents are unchanged from a previous commit, do nothing
+	StatOverrideFile          string         // File containing stat overrides in the format of ParseStatOverrides
+	StatOverrides             []StatOverride // Changes to the permissions and ownership of paths, applied after those of StatOverrideFile
+	SkipListFile              string         // File containing .gitignore-style patterns of paths to skip, one per line
+	SkipPatterns              []string       // .gitignore-style patterns of paths to skip, e.g. "**/*.pyc" or "!/var/cache/keep"
+	SkipRegexps               []string       // Regular expressions matching the paths, starting with "/", to skip
+	AllowUnmatchedSkips       bool           // Do not fail the commit when a skip pattern or regexp matches no path
+	GenerateSizes             bool           // Generate size information along with commit metadata
+	CanonicalPermissions      bool           // Commit files owned by root without setuid, setgid, group or other write bits, as bare-user-only repos require
+	Consume                   bool           // Delete the files of the committed directory as they are committed, moving them into the repo where possible
+	ErrorOnUnlabeled          bool           // Fail if a committed file has no SELinux label
+	DevinoCanonical           bool           // Reuse the checksums of files checked out from the repo by device and inode without reading their xattrs
+	SelinuxPolicy             string         // Path of a tree, usually the one committed, whose SELinux policy labels the committed files
+	GpgSign                   []string       // GPG Key ID with which to sign the commit (if you have GPGME - GNU Privacy Guard Made Easy)
+	GpgHomedir                string         // GPG home directory to use when looking for keyrings (if you have GPGME - GNU Privacy Guard Made Easy)
+	Timestamp                 time.Time      // Override the timestamp of the commit
+	Orphan                    bool           // Commit without a parent; the branch is optional, and still set if given
+	Fsync                     bool           // Specify whether fsync should be used or not.  Default to true
+	CollectionBinding         bool           // Bind the commit to the repo's collection ID and to the branch
+	XattrCallback             XattrCallback  // Supply the extended attributes of paths, along with the other filters
+	FSMetadata                FSMetadataFunc // CommitFS: supply the ownership, mode and extended attributes of entries
 }
 
+// XattrCallback returns the extended attributes to commit for path,
+// relative to the commit root and starting with "/", replacing those of the
+// file, or nil to keep them
+type XattrCallback func(path string) map[string][]byte
+
 // Initializes a commitOptions struct and sets default values
 func NewCommitOptions() commitOptions {
 	var co commitOptions
@@ -58,15 +75,55 @@ func NewCommitOptions() commitOptions {
 	return co
 }
 
-type OstreeRepoTransactionStats struct {
-	metadata_objects_total   int32
-	metadata_objects_written int32
-	content_objects_total    int32
-	content_objects_written  int32
-	content_bytes_written    uint64
+// TransactionStats holds the statistics of a committed transaction
+type TransactionStats struct {
+	MetadataObjectsTotal   uint32 // Total number of metadata objects processed
+	MetadataObjectsWritten uint32 // Number of metadata objects written, i.e. not already in the repo
+	ContentObjectsTotal    uint32 // Total number of content objects processed
+	ContentObjectsWritten  uint32 // Number of content objects written, i.e. not already in the repo
+	ContentBytesWritten    uint64 // Total size of the content objects written
+	DevinoCacheHits        uint32 // Number of files found in the devino cache, e.g. with LinkCheckoutSpeedup
 }
 
+// OstreeRepoTransactionStats is the former name of TransactionStats.
+//
+// Deprecated: use TransactionStats.
+type OstreeRepoTransactionStats = TransactionStats
+
+// transactionStatsFromNative converts C transaction stats to their Go equivalent
+func transactionStatsFromNative(stats *C.OstreeRepoTransactionStats) *TransactionStats {
+	return &TransactionStats{
+		MetadataObjectsTotal:   uint32(stats.metadata_objects_total),
+		MetadataObjectsWritten: uint32(stats.metadata_objects_written),
+		ContentObjectsTotal:    uint32(stats.content_objects_total),
+		ContentObjectsWritten:  uint32(stats.content_objects_written),
+		ContentBytesWritten:    uint64(stats.content_bytes_written),
+		DevinoCacheHits:        uint32(stats.devino_cache_hits),
+	}
+}
+
+// PrepareTransaction starts a transaction to be finished with
+// CommitTransaction or AbortTransaction, and returns whether it resumed an
+// interrupted one.  It fails with ErrTransactionActive if a transaction is
+// already in progress.
 func (repo *Repo) PrepareTransaction() (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.txn != nil || repo.prepared {
+		return false, ErrTransactionActive
+	}
+
+	resume, err := repo.prepareTransaction()
+	if err != nil {
+		return false, err
+	}
+	repo.prepared = true
+	return resume, nil
+}
+
+// prepareTransaction starts a transaction without recording it in the repo
+func (repo *Repo) prepareTransaction() (bool, error) {
+	defer runtime.KeepAlive(repo)
 	var cerr *C.GError = nil
 	var resume C.gboolean
 
@@ -77,44 +134,56 @@ func (repo *Repo) PrepareTransaction() (bool, error) {
 	return glib.GoBool(glib.GBoolean(resume)), nil
 }
 
-func (repo *Repo) CommitTransaction() (*OstreeRepoTransactionStats, error) {
+func (repo *Repo) CommitTransaction() (*TransactionStats, error) {
+	defer runtime.KeepAlive(repo)
 	var cerr *C.GError = nil
-	var stats OstreeRepoTransactionStats = OstreeRepoTransactionStats{}
-	statsPtr := (*C.OstreeRepoTransactionStats)(unsafe.Pointer(&stats))
-	r := glib.GoBool(glib.GBoolean(C.ostree_repo_commit_transaction(repo.native(), statsPtr, nil, &cerr)))
+	var stats C.OstreeRepoTransactionStats
+	r := glib.GoBool(glib.GBoolean(C.ostree_repo_commit_transaction(repo.native(), &stats, nil, &cerr)))
 	if !r {
 		return nil, generateError(cerr)
 	}
-	return &stats, nil
+	repo.mu.Lock()
+	repo.prepared = false
+	repo.mu.Unlock()
+	return transactionStatsFromNative(&stats), nil
 }
 
 func (repo *Repo) TransactionSetRef(remote string, ref string, checksum string) {
+	defer runtime.KeepAlive(repo)
 	var cRemote *C.char = nil
 	var cRef *C.char = nil
 	var cChecksum *C.char = nil
 
 	if remote != "" {
 		cRemote = C.CString(remote)
+		defer C.free(unsafe.Pointer(cRemote))
 	}
 	if ref != "" {
 		cRef = C.CString(ref)
+		defer C.free(unsafe.Pointer(cRef))
 	}
 	if checksum != "" {
 		cChecksum = C.CString(checksum)
+		defer C.free(unsafe.Pointer(cChecksum))
 	}
 	C.ostree_repo_transaction_set_ref(repo.native(), cRemote, cRef, cChecksum)
 }
 
 func (repo *Repo) AbortTransaction() error {
+	defer runtime.KeepAlive(repo)
 	var cerr *C.GError = nil
 	r := glib.GoBool(glib.GBoolean(C.ostree_repo_abort_transaction(repo.native(), nil, &cerr)))
 	if !r {
 		return generateError(cerr)
 	}
+	repo.mu.Lock()
+	repo.prepared = false
+	repo.mu.Unlock()
 	return nil
 }
 
 func (repo *Repo) RegenerateSummary() error {
+	defer runtime.KeepAlive(repo)
 	var cerr *C.GError = nil
 	r := glib.GoBool(glib.GBoolean(C.ostree_repo_regenerate_summary(repo.native(), nil, nil, &cerr)))
 	if !r {
@@ -123,23 +192,65 @@ func (repo *Repo) RegenerateSummary() error {
 	return nil
 }
 
-// Commits a directory, specified by commitPath, to an ostree repo as a given branch
-func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string, error) {
+// CommitResult is the result of Repo.Commit and Repo.CommitFS
+type CommitResult struct {
+	Checksum string            // Checksum of the commit, or of the parent if Skipped
+	Skipped  bool              // No commit was written as the tree is unchanged from the parent, with SkipIfUnchanged
+	Parent   string            // Checksum of the parent commit, empty if there is none
+	RootTree string            // Checksum of the root dirtree of the commit
+	Stats    *TransactionStats // Statistics of the transaction, if Commit ran its own
+}
+
+// Commits a directory, specified by commitPath, to an ostree repo as a given branch.
+// If a transaction is in progress, it is left untouched on error: the
+// caller is responsible for rolling it back.  Otherwise, the commit runs in
+// its own transaction, whose statistics are returned.
+func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (*CommitResult, error) {
+	if repo.inTransaction() {
+		return repo.commit(commitPath, branch, opts)
+	}
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result, err := repo.commit(commitPath, branch, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.Stats, err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// commit implements Commit within a transaction
+func (repo *Repo) commit(commitPath, branch string, opts commitOptions) (*CommitResult, error) {
+	defer runtime.KeepAlive(repo)
 	// TODO(lucab): `options` is global un-synchronized mutable state, get rid of it.
 	options = opts
 
 	var err error
-	var modeAdds *glib.GHashTable
-	var skipList *glib.GHashTable
+	var overrides []StatOverride
+	var skipPatterns []string
+	var filter *commitFilter
+	var filterHandle cgo.Handle
 	var objectToCommit *glib.GFile
-	var skipCommit bool = false
+	var result CommitResult
+	var cparent *C.char
+	defer func() { C.free(unsafe.Pointer(cparent)) }()
 	var ccommitChecksum *C.char
-	defer C.free(unsafe.Pointer(ccommitChecksum))
+	defer func() { C.g_free(C.gpointer(ccommitChecksum)) }()
 	var flags C.OstreeRepoCommitModifierFlags = 0
-	var filter_data C.CommitFilterData
+	var sepolicy *C.OstreeSePolicy
+	defer func() {
+		if sepolicy != nil {
+			C.g_object_unref(C.gpointer(sepolicy))
+		}
+	}()
 
 	var cerr *C.GError
-	defer C.free(unsafe.Pointer(cerr))
 	var metadata *C.GVariant = nil
 	defer func() {
 		if metadata != nil {
@@ -148,15 +259,30 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 	}()
 
 	var detachedMetadata *C.GVariant = nil
-	defer C.free(unsafe.Pointer(detachedMetadata))
+	defer func() {
+		if detachedMetadata != nil {
+			C.g_variant_unref(detachedMetadata)
+		}
+	}()
 	var mtree *C.OstreeMutableTree
-	defer C.free(unsafe.Pointer(mtree))
+	defer func() {
+		if mtree != nil {
+			C.g_object_unref(C.gpointer(mtree))
+		}
+	}()
 	var root *C.GFile
-	defer C.free(unsafe.Pointer(root))
+	defer func() {
+		if root != nil {
+			C.g_object_unref(C.gpointer(root))
+		}
+	}()
 	var modifier *C.OstreeRepoCommitModifier
-	defer C.free(unsafe.Pointer(modifier))
+	defer func() {
+		if modifier != nil {
+			C.ostree_repo_commit_modifier_unref(modifier)
+		}
+	}()
 	var cancellable *C.GCancellable
-	defer C.free(unsafe.Pointer(cancellable))
 
 	cpath := C.CString(commitPath)
 	defer C.free(unsafe.Pointer(cpath))
@@ -166,8 +292,6 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 	defer C.free(unsafe.Pointer(cbody))
 	cbranch := C.CString(branch)
 	defer C.free(unsafe.Pointer(cbranch))
-	cparent := C.CString(options.Parent)
-	defer C.free(unsafe.Pointer(cparent))
 
 	if !glib.GoBool(glib.GBoolean(C.ostree_repo_is_writable(repo.native(), &cerr))) {
 		goto out
@@ -175,19 +299,30 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 
 	// If the user provided a stat override file
 	if strings.Compare(options.StatOverrideFile, "") != 0 {
-		modeAdds = glib.ToGHashTable(unsafe.Pointer(C._g_hash_table_new_full()))
-		if err = parseFileByLine(options.StatOverrideFile, handleStatOverrideLine, modeAdds, cancellable); err != nil {
+		if overrides, err = readStatOverrideFile(options.StatOverrideFile); err != nil {
 			goto out
 		}
 	}
+	overrides = append(overrides, options.StatOverrides...)
 
 	// If the user provided a skiplist file
 	if strings.Compare(options.SkipListFile, "") != 0 {
-		skipList = glib.ToGHashTable(unsafe.Pointer(C._g_hash_table_new_full()))
-		if err = parseFileByLine(options.SkipListFile, handleSkipListline, skipList, cancellable); err != nil {
+		if skipPatterns, err = readSkipListFile(options.SkipListFile); err != nil {
 			goto out
 		}
 	}
+	skipPatterns = append(skipPatterns, options.SkipPatterns...)
+	filter = &commitFilter{
+		ownerUID: options.OwnerUID,
+		ownerGID: options.OwnerGID,
+		xattrs:   options.XattrCallback,
+	}
+	if filter.overrides, err = newStatOverrides(overrides); err != nil {
+		goto out
+	}
+	if filter.skip, err = newSkipMatcher(skipPatterns, options.SkipRegexps); err != nil {
+		goto out
+	}
 
 	if options.AddMetadataString != nil {
 		metadata, err = parseKeyValueStrings(options.AddMetadataString)
@@ -196,8 +331,17 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 		}
 	}
 
+	if options.CollectionBinding {
+		ccollectionID := C.ostree_repo_get_collection_id(repo.native())
+		if ccollectionID == nil {
+			err = errors.New("Collection binding requires the repo to have a collection ID")
+			goto out
+		}
+		metadata = addCollectionBinding(metadata, C.GoString((*C.char)(ccollectionID)), branch)
+	}
+
 	if options.AddDetachedMetadataString != nil {
-		_, err = parseKeyValueStrings(options.AddDetachedMetadataString)
+		detachedMetadata, err = parseKeyValueStrings(options.AddDetachedMetadataString)
 		if err != nil {
 			goto out
 		}
@@ -214,27 +358,51 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 	if options.GenerateSizes {
 		C._ostree_repo_append_modifier_flags(&flags, C.OSTREE_REPO_COMMIT_MODIFIER_FLAGS_GENERATE_SIZES)
 	}
+	if options.CanonicalPermissions {
+		C._ostree_repo_append_modifier_flags(&flags, C.OSTREE_REPO_COMMIT_MODIFIER_FLAGS_CANONICAL_PERMISSIONS)
+	}
+	if options.Consume {
+		C._ostree_repo_append_modifier_flags(&flags, C.OSTREE_REPO_COMMIT_MODIFIER_FLAGS_CONSUME)
+	}
+	if options.ErrorOnUnlabeled {
+		C._ostree_repo_append_modifier_flags(&flags, C.OSTREE_REPO_COMMIT_MODIFIER_FLAGS_ERROR_ON_UNLABELED)
+	}
+	if options.DevinoCanonical {
+		C._ostree_repo_append_modifier_flags(&flags, C.OSTREE_REPO_COMMIT_MODIFIER_FLAGS_DEVINO_CANONICAL)
+	}
 	if !options.Fsync {
 		C.ostree_repo_set_disable_fsync(repo.native(), C.TRUE)
 	}
 
-	if flags != 0 || options.OwnerUID >= 0 || options.OwnerGID >= 0 || strings.Compare(options.StatOverrideFile, "") != 0 || options.NoXattrs {
-		filter_data.mode_adds = (*C.GHashTable)(modeAdds.Ptr())
-		filter_data.skip_list = (*C.GHashTable)(skipList.Ptr())
-		C._set_owner_uid((C.guint32)(options.OwnerUID))
-		C._set_owner_gid((C.guint32)(options.OwnerGID))
-		modifier = C._ostree_repo_commit_modifier_new_wrapper(flags, C.gpointer(&filter_data), nil)
+	filterHandle = cgo.NewHandle(filter)
+	defer filterHandle.Delete()
+	if filter.active() {
+		modifier = C._ostree_repo_commit_modifier_new_go(flags, C.uintptr_t(filterHandle))
+	} else if flags != 0 || options.SelinuxPolicy != "" || options.XattrCallback != nil {
+		modifier = C.ostree_repo_commit_modifier_new(flags, nil, nil, nil)
+	}
+	if options.XattrCallback != nil {
+		C._ostree_repo_commit_modifier_set_xattr_callback_go(modifier, C.uintptr_t(filterHandle))
 	}
 
-	if strings.Compare(options.Parent, "") != 0 {
-		if strings.Compare(options.Parent, "none") == 0 {
-			options.Parent = ""
-		}
-	} else if !options.Orphan {
+	if options.SelinuxPolicy != "" {
+		cpolicyPath := C.CString(options.SelinuxPolicy)
+		policyRoot := C.g_file_new_for_path(cpolicyPath)
+		C.free(unsafe.Pointer(cpolicyPath))
 		cerr = nil
-		if !glib.GoBool(glib.GBoolean(C.ostree_repo_resolve_rev(repo.native(), cbranch, C.TRUE, &cparent, &cerr))) {
+		sepolicy = C.ostree_sepolicy_new(policyRoot, cancellable, &cerr)
+		C.g_object_unref(C.gpointer(policyRoot))
+		if sepolicy == nil {
 			goto out
 		}
+		C.ostree_repo_commit_modifier_set_sepolicy(modifier, sepolicy)
+	}
+
+	if result.Parent, err = repo.resolveCommitParent(branch, options); err != nil {
+		goto out
+	}
+	if result.Parent != "" {
+		cparent = C.CString(result.Parent)
 	}
 
 	if options.LinkCheckoutSpeedup && !glib.GoBool(glib.GBoolean(C.ostree_repo_scan_hardlinks(repo.native(), cancellable, &cerr))) {
@@ -247,84 +415,66 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 		currentDir := (*C.char)(C.g_get_current_dir())
 		objectToCommit = glib.ToGFile(unsafe.Pointer(C.g_file_new_for_path(currentDir)))
 		C.g_free(C.gpointer(currentDir))
+		defer C.g_object_unref(C.gpointer(objectToCommit.Ptr()))
 
 		if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_directory_to_mtree(repo.native(), (*C.GFile)(objectToCommit.Ptr()), mtree, modifier, cancellable, &cerr))) {
 			goto out
 		}
 	} else if len(options.Tree) != 0 {
-		var eq int = -1
-		cerr = nil
-		for tree := range options.Tree {
-			eq = strings.Index(options.Tree[tree], "=")
-			if eq == -1 {
-				C._g_set_error_onearg(cerr, C.CString("Missing type in tree specification"), C.CString(options.Tree[tree]))
+		// Each tree is written over the previous ones
+		for _, spec := range options.Tree {
+			var treeType, treeVal string
+			if treeType, treeVal, err = parseTreeSpec(spec); err != nil {
 				goto out
 			}
-			treeType := options.Tree[tree][:eq]
-			treeVal := options.Tree[tree][eq+1:]
+			ctreeVal := C.CString(treeVal)
+			defer C.free(unsafe.Pointer(ctreeVal))
 
-			if strings.Compare(treeType, "dir") == 0 {
-				objectToCommit = glib.ToGFile(unsafe.Pointer(C.g_file_new_for_path(C.CString(treeVal))))
-				if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_directory_to_mtree(repo.native(), (*C.GFile)(objectToCommit.Ptr()), mtree, modifier, cancellable, &cerr))) {
+			cerr = nil
+			switch treeType {
+			case "dir":
+				treeDir := C.g_file_new_for_path(ctreeVal)
+				defer C.g_object_unref(C.gpointer(treeDir))
+				if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_directory_to_mtree(repo.native(), treeDir, mtree, modifier, cancellable, &cerr))) {
+					err = fmt.Errorf("tree %q: %s", spec, generateError(cerr))
 					goto out
 				}
-			} else if strings.Compare(treeType, "tar") == 0 {
-				objectToCommit = glib.ToGFile(unsafe.Pointer(C.g_file_new_for_path(C.CString(treeVal))))
-				if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_archive_to_mtree(repo.native(), (*C.GFile)(objectToCommit.Ptr()), mtree, modifier, (C.gboolean)(glib.GBool(opts.TarAutoCreateParents)), cancellable, &cerr))) {
-					fmt.Println("error 1")
+			case "tar":
+				treeTar := C.g_file_new_for_path(ctreeVal)
+				defer C.g_object_unref(C.gpointer(treeTar))
+				if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_archive_to_mtree(repo.native(), treeTar, mtree, modifier, (C.gboolean)(glib.GBool(opts.TarAutoCreateParents)), cancellable, &cerr))) {
+					err = fmt.Errorf("tree %q: %s", spec, generateError(cerr))
 					goto out
 				}
-			} else if strings.Compare(treeType, "ref") == 0 {
-				if !glib.GoBool(glib.GBoolean(C.ostree_repo_read_commit(repo.native(), C.CString(treeVal), (**C.GFile)(objectToCommit.Ptr()), nil, cancellable, &cerr))) {
+			case "ref":
+				var refRoot *C.GFile
+				if !glib.GoBool(glib.GBoolean(C.ostree_repo_read_commit(repo.native(), ctreeVal, &refRoot, nil, cancellable, &cerr))) {
+					err = fmt.Errorf("tree %q: %s", spec, generateError(cerr))
 					goto out
 				}
-
-				if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_directory_to_mtree(repo.native(), (*C.GFile)(objectToCommit.Ptr()), mtree, modifier, cancellable, &cerr))) {
+				defer C.g_object_unref(C.gpointer(refRoot))
+				if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_directory_to_mtree(repo.native(), refRoot, mtree, modifier, cancellable, &cerr))) {
+					err = fmt.Errorf("tree %q: %s", spec, generateError(cerr))
 					goto out
 				}
-			} else {
-				C._g_set_error_onearg(cerr, C.CString("Missing type in tree specification"), C.CString(treeVal))
-				goto out
 			}
 		}
 	} else {
 		objectToCommit = glib.ToGFile(unsafe.Pointer(C.g_file_new_for_path(cpath)))
+		defer C.g_object_unref(C.gpointer(objectToCommit.Ptr()))
 		cerr = nil
 		if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_directory_to_mtree(repo.native(), (*C.GFile)(objectToCommit.Ptr()), mtree, modifier, cancellable, &cerr))) {
 			goto out
 		}
 	}
 
-	if modeAdds != nil && C.g_hash_table_size((*C.GHashTable)(modeAdds.Ptr())) > 0 {
-		var hashIter *C.GHashTableIter
-
-		var key, value C.gpointer
-
-		C.g_hash_table_iter_init(hashIter, (*C.GHashTable)(modeAdds.Ptr()))
-
-		for glib.GoBool(glib.GBoolean(C.g_hash_table_iter_next(hashIter, &key, &value))) {
-			C._g_printerr_onearg(C.CString("Unmatched StatOverride path: "), C._gptr_to_str(key))
-		}
-		err = errors.New("Unmatched StatOverride paths")
-		C.free(unsafe.Pointer(hashIter))
-		C.free(unsafe.Pointer(key))
-		C.free(unsafe.Pointer(value))
+	if unmatched := filter.overrides.unmatched(); len(unmatched) > 0 {
+		err = fmt.Errorf("Unmatched stat override paths: %s", strings.Join(unmatched, ", "))
 		goto out
 	}
 
-	if skipList != nil && C.g_hash_table_size((*C.GHashTable)(skipList.Ptr())) > 0 {
-		var hashIter *C.GHashTableIter
-		var key, value C.gpointer
-
-		C.g_hash_table_iter_init(hashIter, (*C.GHashTable)(skipList.Ptr()))
-
-		for glib.GoBool(glib.GBoolean(C.g_hash_table_iter_next(hashIter, &key, &value))) {
-			C._g_printerr_onearg(C.CString("Unmatched SkipList path: "), C._gptr_to_str(key))
-		}
-		err = errors.New("Unmatched SkipList paths")
-		C.free(unsafe.Pointer(hashIter))
-		C.free(unsafe.Pointer(key))
-		C.free(unsafe.Pointer(value))
+	if unmatched := filter.skip.unmatched(); len(unmatched) > 0 && !options.AllowUnmatchedSkips {
+		err = fmt.Errorf("Unmatched skip patterns: %s", strings.Join(unmatched, ", "))
 		goto out
 	}
 
@@ -333,22 +483,23 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 		goto out
 	}
 
-	if options.SkipIfUnchanged && strings.Compare(options.Parent, "") != 0 {
+	result.RootTree = C.GoString(C.ostree_repo_file_tree_get_contents_checksum(C._ostree_repo_file(root)))
+
+	if options.SkipIfUnchanged && cparent != nil {
 		var parentRoot *C.GFile
 
 		cerr = nil
 		if !glib.GoBool(glib.GBoolean(C.ostree_repo_read_commit(repo.native(), cparent, &parentRoot, nil, cancellable, &cerr))) {
-			C.free(unsafe.Pointer(parentRoot))
 			goto out
 		}
 
 		if glib.GoBool(glib.GBoolean(C.g_file_equal(root, parentRoot))) {
-			skipCommit = true
+			result.Skipped = true
 		}
-		C.free(unsafe.Pointer(parentRoot))
+		C.g_object_unref(C.gpointer(parentRoot))
 	}
 
-	if !skipCommit {
+	if !result.Skipped {
 		var timestamp C.guint64
 
 		if options.Timestamp.IsZero() {
@@ -371,46 +522,71 @@ func (repo *Repo) Commit(commitPath, branch string, opts commitOptions) (string,
 		}
 
 		if detachedMetadata != nil {
-			C.ostree_repo_write_commit_detached_metadata(repo.native(), ccommitChecksum, detachedMetadata, cancellable, &cerr)
+			cerr = nil
+			if !glib.GoBool(glib.GBoolean(C.ostree_repo_write_commit_detached_metadata(repo.native(), ccommitChecksum, detachedMetadata, cancellable, &cerr))) {
+				goto out
+			}
 		}
 
 		if len(options.GpgSign) != 0 {
+			var chomedir *C.char
+			if options.GpgHomedir != "" {
+				chomedir = C.CString(options.GpgHomedir)
+				defer C.free(unsafe.Pointer(chomedir))
+			}
 			for key := range options.GpgSign {
-				if !glib.GoBool(glib.GBoolean(C.ostree_repo_sign_commit(repo.native(), (*C.gchar)(ccommitChecksum), (*C.gchar)(C.CString(options.GpgSign[key])), (*C.gchar)(C.CString(options.GpgHomedir)), cancellable, &cerr))) {
+				ckey := C.CString(options.GpgSign[key])
+				ok := glib.GoBool(glib.GBoolean(C.ostree_repo_sign_commit(repo.native(), (*C.gchar)(ccommitChecksum), (*C.gchar)(ckey), (*C.gchar)(chomedir), cancellable, &cerr)))
+				C.free(unsafe.Pointer(ckey))
+				if !ok {
 					goto out
 				}
 			}
 		}
 
+		// Orphan commits without a branch are only reachable by checksum
 		if strings.Compare(branch, "") != 0 {
 			C.ostree_repo_transaction_set_ref(repo.native(), nil, cbranch, ccommitChecksum)
-		} else if !options.Orphan {
-			goto out
-		} else {
-			// TODO: Looks like I forgot to implement this.
 		}
+		result.Checksum = C.GoString(ccommitChecksum)
 	} else {
-		ccommitChecksum = C.CString(options.Parent)
+		result.Checksum = result.Parent
 	}
 
-	return C.GoString(ccommitChecksum), nil
+	return &result, nil
 out:
-	if repo.native() != nil {
-		C.ostree_repo_abort_transaction(repo.native(), cancellable, nil)
-		//C.free(unsafe.Pointer(repo.native()))
+	if err != nil {
+		return nil, err
+	}
+	return nil, generateError(cerr)
+}
+
+// parseTreeSpec splits a 'dir=PATH', 'tar=TARFILE' or 'ref=COMMIT' tree
+// specification into its type and value
+func parseTreeSpec(spec string) (string, string, error) {
+	eq := strings.Index(spec, "=")
+	if eq == -1 {
+		return "", "", fmt.Errorf("Missing type in tree specification %q", spec)
 	}
-	if modifier != nil {
-		C.ostree_repo_commit_modifier_unref(modifier)
+	treeType, treeVal := spec[:eq], spec[eq+1:]
+	switch treeType {
+	case "dir", "tar", "ref":
+	default:
+		return "", "", fmt.Errorf("Invalid tree type %q in tree specification %q", treeType, spec)
 	}
-	if err != nil {
-		return "", err
+	if treeVal == "" {
+		return "", "", fmt.Errorf("Missing value in tree specification %q", spec)
 	}
-	return "", generateError(cerr)
+	return treeType, treeVal, nil
 }
 
 // Parse an array of key value pairs of the format KEY=VALUE and add them to a GVariant
 func parseKeyValueStrings(pairs []string) (*C.GVariant, error) {
-	builder := C.g_variant_builder_new(C._g_variant_type(C.CString("a{sv}")))
+	ctype := C.CString("a{sv}")
+	defer C.free(unsafe.Pointer(ctype))
+	cformat := C.CString("{sv}")
+	defer C.free(unsafe.Pointer(cformat))
+	builder := C.g_variant_builder_new(C._g_variant_type(ctype))
 	defer C.g_variant_builder_unref(builder)
 
 	for iter := range pairs {
@@ -427,57 +603,11 @@ func parseKeyValueStrings(pairs []string) (*C.GVariant, error) {
 
 		valueVariant := C.g_variant_new_string((*C.gchar)(value))
 
-		C._g_variant_builder_add_twoargs(builder, C.CString("{sv}"), key, valueVariant)
+		C._g_variant_builder_add_twoargs(builder, cformat, key, valueVariant)
+		C.free(unsafe.Pointer(key))
+		C.free(unsafe.Pointer(value))
 	}
 
 	metadata := C.g_variant_builder_end(builder)
 	return C.g_variant_ref_sink(metadata), nil
 }
-
-// Parse a file linue by line and handle the line with the handleLineFunc
-func parseFileByLine(path string, fn handleLineFunc, table *glib.GHashTable, cancellable *C.GCancellable) error {
-	var contents *C.char
-	var file *glib.GFile
-	var lines []string
-	var gerr = glib.NewGError()
-	cerr := (*C.GError)(gerr.Ptr())
-
-	file = glib.ToGFile(unsafe.Pointer(C.g_file_new_for_path(C.CString(path))))
-	if !glib.GoBool(glib.GBoolean(C.g_file_load_contents((*C.GFile)(file.Ptr()), cancellable, &contents, nil, nil, &cerr))) {
-		return generateError(cerr)
-	}
-
-	lines = strings.Split(C.GoString(contents), "\n")
-	for line := range lines {
-		if strings.Compare(lines[line], "") == 0 {
-			continue
-		}
-
-		if err := fn(lines[line], table); err != nil {
-			return generateError(cerr)
-		}
-	}
-	return nil
-}
-
-// Handle an individual line from a Statoverride file
-func handleStatOverrideLine(line string, table *glib.GHashTable) error {
-	var space int
-	var modeAdd C.guint
-
-	if space = strings.IndexRune(line, ' '); space == -1 {
-		return errors.New("Malformed StatOverrideFile (no space found)")
-	}
-
-	modeAdd = (C.guint)(C.g_ascii_strtod((*C.gchar)(C.CString(line)), nil))
-	C.g_hash_table_insert((*C.GHashTable)(table.Ptr()), C.gpointer(C.g_strdup((*C.gchar)(C.CString(line[space+1:])))), C._guint_to_pointer(modeAdd))
-
-	return nil
-}
-
-// Handle an individual line from a Skiplist file
-func handleSkipListline(line string, table *glib.GHashTable) error {
-	C.g_hash_table_add((*C.GHashTable)(table.Ptr()), C.gpointer(C.g_strdup((*C.gchar)(C.CString(line)))))
-
-	return nil
-}
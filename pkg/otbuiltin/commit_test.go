@@ -1,10 +1,14 @@
 package otbuiltin
 
 import (
+	"archive/tar"
+	"context"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/14rcole/gopopulate"
@@ -62,7 +66,7 @@ func TestCommitSuccess(t *testing.T) {
 	if err != nil {
 		t.Errorf("%s", err)
 	} else {
-		fmt.Println(ret)
+		fmt.Println(ret.Checksum)
 	}
 	_, err = repo.CommitTransaction()
 	if err != nil {
@@ -184,7 +188,7 @@ func TestCommitTreeParentSuccess(t *testing.T) {
 	if _, err := repo.PrepareTransaction(); err != nil {
 		t.Fatalf("failed to prepare transaction: %s", err)
 	}
-	parentChecksum, err := repo.Commit("", branch, opts)
+	parent, err := repo.Commit("", branch, opts)
 	if err != nil {
 		t.Fatalf("failed to commit: %s", err)
 	}
@@ -196,11 +200,520 @@ func TestCommitTreeParentSuccess(t *testing.T) {
 	if _, err := repo.PrepareTransaction(); err != nil {
 		t.Fatalf("failed to prepare transaction: %s", err)
 	}
-	opts.Parent = parentChecksum
-	if _, err := repo.Commit("", branch, opts); err != nil {
+	opts.Parent = parent.Checksum
+	result, err := repo.Commit("", branch, opts)
+	if err != nil {
 		t.Fatalf("failed to commit with parent: %s", err)
 	}
+	if result.Parent != parent.Checksum {
+		t.Errorf("expected parent %s, got %s", parent.Checksum, result.Parent)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+}
+
+func TestCommitSkipPatterns(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.MkdirAll(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	repoOpts := NewInitOptions()
+	repoOpts.Mode = "archive"
+	if _, err := Init(repoDir, repoOpts); err != nil {
+		t.Fatalf("failed to initialize the repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	// Make a tree with files to skip
+	commitDir := path.Join(baseDir, "commit1")
+	for _, name := range []string{"usr/lib/foo.py", "usr/lib/foo.pyc", "var/cache/dnf/db", "var/cache/keep", "etc/passwd.bak"} {
+		if err := os.MkdirAll(path.Join(commitDir, path.Dir(name)), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		if err := ioutil.WriteFile(path.Join(commitDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+	}
+	skipListFile := path.Join(baseDir, "skip-list")
+	if err := ioutil.WriteFile(skipListFile, []byte("# caches\n/var/cache/**\n!/var/cache/keep\n"), 0644); err != nil {
+		t.Fatalf("failed to write skip list: %s", err)
+	}
+
+	opts := NewCommitOptions()
+	opts.SkipListFile = skipListFile
+	opts.SkipPatterns = []string{"**/*.pyc"}
+	opts.SkipRegexps = []string{`\.bak$`}
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	result, err := repo.Commit(commitDir, "skip", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+
+	// A pattern matching nothing fails the commit, unless allowed
+	opts.SkipPatterns = append(opts.SkipPatterns, "/nonexistent")
+	if _, err := repo.Commit(commitDir, "skip", opts); err == nil || !strings.Contains(err.Error(), "/nonexistent") {
+		t.Errorf("expected an unmatched pattern error, got %v", err)
+	}
+	opts.AllowUnmatchedSkips = true
+	if _, err := repo.Commit(commitDir, "skip", opts); err != nil {
+		t.Errorf("failed to commit with unmatched patterns allowed: %s", err)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	fsys, err := repo.OpenCommitFS(result.Checksum)
+	if err != nil {
+		t.Fatalf("failed to open commit: %s", err)
+	}
+	for name, exists := range map[string]bool{
+		"usr/lib/foo.py":  true,
+		"usr/lib/foo.pyc": false,
+		"var/cache":       true,
+		"var/cache/dnf":   false,
+		"var/cache/keep":  true,
+		"etc/passwd.bak":  false,
+		"etc":             true,
+	} {
+		if _, err := fs.Stat(fsys, name); (err == nil) != exists {
+			t.Errorf("expected %s to exist: %v, got %v", name, exists, err)
+		}
+	}
+}
+
+func TestCommitModifierFlags(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.MkdirAll(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	repoOpts := NewInitOptions()
+	repoOpts.Mode = "archive"
+	if _, err := Init(repoDir, repoOpts); err != nil {
+		t.Fatalf("failed to initialize the repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.MkdirAll(path.Join(commitDir, "usr", "bin"), 0775); err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+	toolPath := path.Join(commitDir, "usr", "bin", "tool")
+	if err := ioutil.WriteFile(toolPath, []byte("#!/bin/sh\n"), 0777); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Chmod(toolPath, 0777|os.ModeSetuid); err != nil {
+		t.Fatalf("failed to chmod file: %s", err)
+	}
+	// Without a policy in the tree, files are committed unlabeled
+	policyDir := path.Join(baseDir, "policy")
+	if err := os.Mkdir(policyDir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+
+	opts := NewCommitOptions()
+	opts.CanonicalPermissions = true
+	opts.Consume = true
+	opts.SelinuxPolicy = policyDir
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	result, err := repo.Commit(commitDir, "flags", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	fsys, err := repo.OpenCommitFS(result.Checksum)
+	if err != nil {
+		t.Fatalf("failed to open commit: %s", err)
+	}
+	info, err := fs.Stat(fsys, "usr/bin/tool")
+	if err != nil {
+		t.Fatalf("failed to stat file: %s", err)
+	}
+	if sys := info.Sys().(*CommitFileSys); info.Mode() != 0755 || sys.UID != 0 || sys.GID != 0 {
+		t.Errorf("expected canonical permissions, got mode %s owned by %d:%d", info.Mode(), sys.UID, sys.GID)
+	}
+	if _, err := os.Stat(toolPath); !os.IsNotExist(err) {
+		t.Errorf("expected the committed file to be consumed, got %v", err)
+	}
+}
+
+func TestCommitXattrCallback(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.MkdirAll(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	repoOpts := NewInitOptions()
+	repoOpts.Mode = "archive"
+	if _, err := Init(repoDir, repoOpts); err != nil {
+		t.Fatalf("failed to initialize the repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	commitDir := path.Join(baseDir, "commit1")
+	for _, name := range []string{"usr/bin/tool", "usr/bin/other", "etc/motd"} {
+		if err := os.MkdirAll(path.Join(commitDir, path.Dir(name)), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		if err := ioutil.WriteFile(path.Join(commitDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+	}
+
+	var paths []string
+	opts := NewCommitOptions()
+	opts.SkipPatterns = []string{"/etc/motd"}
+	opts.XattrCallback = func(path string) map[string][]byte {
+		paths = append(paths, path)
+		if path == "/usr/bin/tool" {
+			return map[string][]byte{"user.test": []byte("value")}
+		}
+		return nil
+	}
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	result, err := repo.Commit(commitDir, "xattrs", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	// The callback applies together with the filter, so skipped paths
+	// aren't seen
+	for _, p := range paths {
+		if p == "/etc/motd" {
+			t.Errorf("unexpected xattr callback for skipped path %s", p)
+		}
+	}
+
+	fsys, err := repo.OpenCommitFS(result.Checksum)
+	if err != nil {
+		t.Fatalf("failed to open commit: %s", err)
+	}
+	for name, value := range map[string]string{"usr/bin/tool": "value", "usr/bin/other": ""} {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %s", name, err)
+		}
+		if xattr := string(info.Sys().(*CommitFileSys).Xattrs["user.test"]); xattr != value {
+			t.Errorf("expected user.test of %s to be %q, got %q", name, value, xattr)
+		}
+	}
+}
+
+func TestCommitTreeOverlays(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.MkdirAll(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	repoOpts := NewInitOptions()
+	repoOpts.Mode = "archive"
+	if _, err := Init(repoDir, repoOpts); err != nil {
+		t.Fatalf("failed to initialize the repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	writeFiles := func(dir string, files map[string]string) {
+		for name, data := range files {
+			if err := os.MkdirAll(path.Join(dir, path.Dir(name)), 0755); err != nil {
+				t.Fatalf("failed to create dir: %s", err)
+			}
+			if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatalf("failed to write file: %s", err)
+			}
+		}
+	}
+	baseTree := path.Join(baseDir, "base")
+	writeFiles(baseTree, map[string]string{"etc/motd": "base", "etc/hostname": "base", "usr/lib/base": "base"})
+	appTree := path.Join(baseDir, "app")
+	writeFiles(appTree, map[string]string{"etc/motd": "app", "opt/app/bin": "app"})
+
+	tarPath := path.Join(baseDir, "extra.tar")
+	tarFile, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatalf("failed to create tar: %s", err)
+	}
+	tw := tar.NewWriter(tarFile)
+	for name, data := range map[string]string{"etc/hostname": "extra", "usr/share/extra": "extra"} {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %s", err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatalf("failed to write tar entry: %s", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write tar: %s", err)
+	}
+	tarFile.Close()
+
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	defer repo.AbortTransaction()
+	if _, err := repo.Commit(baseTree, "base", NewCommitOptions()); err != nil {
+		t.Fatalf("failed to commit base: %s", err)
+	}
+
+	// Later trees override the files of the earlier ones
+	opts := NewCommitOptions()
+	opts.Tree = []string{"ref=base", "dir=" + appTree, "tar=" + tarPath}
+	opts.TarAutoCreateParents = true
+	result, err := repo.Commit("", "layered", opts)
+	if err != nil {
+		t.Fatalf("failed to commit layered trees: %s", err)
+	}
+
+	for _, tree := range []string{"dir", "dir=", "file=" + appTree, "ref=nonexistent", "dir=" + path.Join(baseDir, "nonexistent")} {
+		opts.Tree = []string{tree}
+		if _, err := repo.Commit("", "bad", opts); err == nil || !strings.Contains(err.Error(), "tree") {
+			t.Errorf("expected a tree specification error for %q, got %v", tree, err)
+		}
+	}
 	if _, err := repo.CommitTransaction(); err != nil {
 		t.Fatalf("failed to commit transaction: %s", err)
 	}
+
+	fsys, err := repo.OpenCommitFS(result.Checksum)
+	if err != nil {
+		t.Fatalf("failed to open commit: %s", err)
+	}
+	for name, expected := range map[string]string{
+		"etc/motd":        "app",
+		"etc/hostname":    "extra",
+		"usr/lib/base":    "base",
+		"opt/app/bin":     "app",
+		"usr/share/extra": "extra",
+	} {
+		if data, err := fs.ReadFile(fsys, name); err != nil || string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q: %v", name, expected, data, err)
+		}
+	}
+}
+
+func TestCommitResult(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.MkdirAll(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	repoOpts := NewInitOptions()
+	repoOpts.Mode = "archive"
+	if _, err := Init(repoDir, repoOpts); err != nil {
+		t.Fatalf("failed to initialize the repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	defer repo.Close()
+
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.Mkdir(commitDir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte("first"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	// Without a transaction, Commit runs its own
+	first, err := repo.Commit(commitDir, "main", NewCommitOptions())
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if len(first.Checksum) != 64 || len(first.RootTree) != 64 || first.Parent != "" || first.Skipped {
+		t.Errorf("unexpected first commit %+v", first)
+	}
+	if first.Stats == nil || first.Stats.ContentObjectsWritten != 1 {
+		t.Errorf("unexpected transaction stats %+v", first.Stats)
+	}
+	if rev, err := repo.ResolveRev("main", false); err != nil || rev != first.Checksum {
+		t.Errorf("expected main to be %s, got %s: %v", first.Checksum, rev, err)
+	}
+
+	// Unchanged trees are skipped, reporting the parent
+	opts := NewCommitOptions()
+	opts.SkipIfUnchanged = true
+	skipped, err := repo.Commit(commitDir, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if !skipped.Skipped || skipped.Checksum != first.Checksum || skipped.Parent != first.Checksum || skipped.RootTree != first.RootTree {
+		t.Errorf("unexpected skipped commit %+v", skipped)
+	}
+
+	if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte("second"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	// Within a transaction, Commit joins it and has no stats
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	second, err := repo.Commit(commitDir, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if second.Skipped || second.Parent != first.Checksum || second.RootTree == first.RootTree || second.Stats != nil {
+		t.Errorf("unexpected second commit %+v", second)
+	}
+
+	// "none" commits without a parent
+	opts.Parent = "none"
+	noParent, err := repo.Commit(commitDir, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit without parent: %s", err)
+	}
+	if noParent.Parent != "" || noParent.Skipped {
+		t.Errorf("unexpected commit without parent %+v", noParent)
+	}
+
+	// Orphan commits without a branch set no ref
+	opts = NewCommitOptions()
+	opts.Orphan = true
+	orphan, err := repo.Commit(commitDir, "", opts)
+	if err != nil {
+		t.Fatalf("failed to commit orphan: %s", err)
+	}
+	if len(orphan.Checksum) != 64 || orphan.Parent != "" {
+		t.Errorf("unexpected orphan commit %+v", orphan)
+	}
+
+	// Orphan commits with a branch don't take it as parent, but still set it
+	opts.Subject = "orphan"
+	orphanMain, err := repo.Commit(commitDir, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit orphan on main: %s", err)
+	}
+	if orphanMain.Parent != "" || orphanMain.Checksum == noParent.Checksum {
+		t.Errorf("unexpected orphan commit on main %+v", orphanMain)
+	}
+	if _, err := repo.Commit(commitDir, "", NewCommitOptions()); err == nil {
+		t.Error("got unexpected nil error committing without branch")
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	refs, err := repo.ListRefs("")
+	if err != nil {
+		t.Fatalf("failed to list refs: %s", err)
+	}
+	if len(refs) != 1 || refs["main"] != orphanMain.Checksum {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	if _, _, err := repo.ReadCommitObject(orphan.Checksum); err != nil {
+		t.Errorf("failed to read orphan commit: %s", err)
+	}
+}
+
+func TestCommitDetachedMetadata(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if _, err := InitRepo(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize the repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	defer repo.Close()
+
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.Mkdir(commitDir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+
+	opts := NewCommitOptions()
+	opts.AddDetachedMetadataString = []string{"signed-by=test", "build=42"}
+	result, err := repo.Commit(commitDir, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+
+	metadata, err := repo.ReadCommitDetachedMetadata(result.Checksum)
+	if err != nil {
+		t.Fatalf("failed to read detached metadata: %s", err)
+	}
+	if metadata == nil {
+		t.Fatal("commit has no detached metadata")
+	}
+	for key, expected := range map[string]string{"signed-by": "test", "build": "42"} {
+		if value, err := metadata.LookupString(key); err != nil || value != expected {
+			t.Errorf("expected detached metadata %s=%s, got %q: %v", key, expected, value, err)
+		}
+	}
+
+	// Commits without detached metadata have none
+	result, err = repo.Commit(commitDir, "other", NewCommitOptions())
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if metadata, err := repo.ReadCommitDetachedMetadata(result.Checksum); err != nil || metadata != nil {
+		t.Errorf("expected no detached metadata, got %v: %v", metadata, err)
+	}
 }
@@ -0,0 +1,77 @@
+package otbuiltin
+
+import (
+	"runtime/cgo"
+)
+
+// This file exports Go callbacks to C, so its preamble may only hold
+// declarations; the C trampolines calling them are in builtin.go.h.
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+import "C"
+
+// commitFilter is the state of the commit filter and xattr callbacks of a
+// Repo.Commit call, passed to C through a cgo.Handle
+type commitFilter struct {
+	ownerUID  int
+	ownerGID  int
+	overrides *statOverrides
+	skip      *skipMatcher
+	xattrs    XattrCallback
+}
+
+// active reports whether the filter changes anything, so that commits
+// which don't need it avoid the filter callbacks
+func (f *commitFilter) active() bool {
+	return f.ownerUID >= 0 || f.ownerGID >= 0 || !f.overrides.empty() || !f.skip.empty()
+}
+
+// apply filters the file at path, relative to the commit root, and returns
+// whether to commit it
+func (f *commitFilter) apply(path string, info *C.GFileInfo) bool {
+	if f.skip.skip(path, C.g_file_info_get_file_type(info) == C.G_FILE_TYPE_DIRECTORY) {
+		return false
+	}
+
+	if f.ownerUID >= 0 {
+		setFileInfoUint32(info, "unix::uid", uint32(f.ownerUID))
+	}
+	if f.ownerGID >= 0 {
+		setFileInfoUint32(info, "unix::gid", uint32(f.ownerGID))
+	}
+
+	if !f.overrides.empty() {
+		mode := fileInfoUint32(info, "unix::mode")
+		uid := fileInfoUint32(info, "unix::uid")
+		gid := fileInfoUint32(info, "unix::gid")
+		if f.overrides.apply(path, &mode, &uid, &gid) {
+			setFileInfoUint32(info, "unix::mode", mode)
+			setFileInfoUint32(info, "unix::uid", uid)
+			setFileInfoUint32(info, "unix::gid", gid)
+		}
+	}
+	return true
+}
+
+//export goCommitXattrCallback
+func goCommitXattrCallback(repo *C.OstreeRepo, path *C.char, info *C.GFileInfo, userData C.gpointer) *C.GVariant {
+	f := cgo.Handle(uintptr(userData)).Value().(*commitFilter)
+	xattrs := f.xattrs(C.GoString(path))
+	if xattrs == nil {
+		// Keep the extended attributes of the file
+		return nil
+	}
+	return xattrsToVariant(xattrs)
+}
+
+//export goCommitFilter
+func goCommitFilter(repo *C.OstreeRepo, path *C.char, info *C.GFileInfo, userData C.gpointer) C.OstreeRepoCommitFilterResult {
+	f := cgo.Handle(uintptr(userData)).Value().(*commitFilter)
+	if !f.apply(C.GoString(path), info) {
+		return C.OSTREE_REPO_COMMIT_FILTER_SKIP
+	}
+	return C.OSTREE_REPO_COMMIT_FILTER_ALLOW
+}
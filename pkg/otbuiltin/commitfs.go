@@ -0,0 +1,294 @@
+package otbuiltin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	glib "github.com/ostreedev/ostree-go/pkg/glibobject"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// FSMetadataFunc supplies the metadata of the entry name of an fs.FS
+// committed with CommitFS.  meta holds the defaults derived from info and
+// the OwnerUID/OwnerGID options; the returned FileInfo and extended
+// attributes are committed instead.
+type FSMetadataFunc func(name string, info fs.FileInfo, meta FileInfo) (FileInfo, map[string][]byte, error)
+
+// readLinkFS is implemented by file systems supporting symbolic links, like
+// fs.ReadLinkFS
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// CommitFS commits the tree of fsys to an ostree repo as a given branch.
+// fs.FileInfo holds no ownership or extended attributes: files are owned by
+// OwnerUID/OwnerGID, or root, unless opts.FSMetadata supplies them.
+// Symbolic links require fsys to implement ReadLink, as fs.ReadLinkFS does.
+//
+// Only the Subject, Body, Parent, AddMetadataString, OwnerUID, OwnerGID,
+// NoXattrs, Timestamp, Orphan, SkipIfUnchanged, CollectionBinding,
+// StatOverrideFile, StatOverrides, SkipPatterns, SkipRegexps,
+// AllowUnmatchedSkips, XattrCallback and FSMetadata options apply; stat
+// overrides and XattrCallback apply after FSMetadata.  Like Commit, it runs
+// in its own transaction unless one is in progress, which is left untouched
+// on error.
+func (repo *Repo) CommitFS(fsys fs.FS, branch string, opts commitOptions) (*CommitResult, error) {
+	if !repo.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	if repo.inTransaction() {
+		return repo.commitFS(fsys, branch, opts)
+	}
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result, err := repo.commitFS(fsys, branch, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.Stats, err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// commitFS implements CommitFS within a transaction
+func (repo *Repo) commitFS(fsys fs.FS, branch string, opts commitOptions) (*CommitResult, error) {
+	defer runtime.KeepAlive(repo)
+	if branch == "" && !opts.Orphan {
+		return nil, errors.New("A branch must be specified or use commitOptions.Orphan")
+	}
+
+	skip, err := newSkipMatcher(opts.SkipPatterns, opts.SkipRegexps)
+	if err != nil {
+		return nil, err
+	}
+	var overrideList []StatOverride
+	if opts.StatOverrideFile != "" {
+		if overrideList, err = readStatOverrideFile(opts.StatOverrideFile); err != nil {
+			return nil, err
+		}
+	}
+	overrides, err := newStatOverrides(append(overrideList, opts.StatOverrides...))
+	if err != nil {
+		return nil, err
+	}
+
+	root := NewMutableTree()
+	dirs := map[string]*MutableTree{".": root}
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && skip.skip(name, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		meta, xattrs, err := fsMetadata(fsys, name, info, opts)
+		if err != nil {
+			return err
+		}
+		overrides.apply(name, &meta.Mode, &meta.UID, &meta.GID)
+
+		parent := dirs[path.Dir(name)]
+		if d.IsDir() {
+			checksum, err := repo.WriteDirMeta(meta, xattrs)
+			if err != nil {
+				return err
+			}
+			dir := root
+			if name != "." {
+				if dir, err = parent.EnsureDir(path.Base(name)); err != nil {
+					return err
+				}
+			}
+			dir.SetMetadataChecksum(checksum)
+			dirs[name] = dir
+			return nil
+		}
+
+		var checksum string
+		switch meta.Mode & modeTypeMask {
+		case modeTypeSymlink:
+			checksum, err = repo.WriteContent(nil, meta, xattrs)
+		case modeTypeRegular:
+			var f fs.File
+			if f, err = fsys.Open(name); err != nil {
+				return err
+			}
+			checksum, err = repo.WriteContent(f, meta, xattrs)
+			f.Close()
+		default:
+			return fmt.Errorf("%s: unsupported file type %s", name, info.Mode().Type())
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return parent.ReplaceFile(path.Base(name), checksum)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmatched := skip.unmatched(); len(unmatched) > 0 && !opts.AllowUnmatchedSkips {
+		return nil, fmt.Errorf("Unmatched skip patterns: %s", strings.Join(unmatched, ", "))
+	}
+	if unmatched := overrides.unmatched(); len(unmatched) > 0 {
+		return nil, fmt.Errorf("Unmatched stat override paths: %s", strings.Join(unmatched, ", "))
+	}
+
+	result := &CommitResult{}
+	if result.RootTree, err = repo.WriteMTree(root); err != nil {
+		return nil, err
+	}
+	if result.Parent, err = repo.resolveCommitParent(branch, opts); err != nil {
+		return nil, err
+	}
+
+	if opts.SkipIfUnchanged && result.Parent != "" {
+		_, parent, err := repo.ReadCommitObject(result.Parent)
+		if err != nil {
+			return nil, err
+		}
+		if parent.RootTree == result.RootTree && parent.RootMeta == root.MetadataChecksum() {
+			result.Skipped = true
+			result.Checksum = result.Parent
+			return result, nil
+		}
+	}
+
+	var cmetadata *C.GVariant
+	if opts.AddMetadataString != nil {
+		if cmetadata, err = parseKeyValueStrings(opts.AddMetadataString); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CollectionBinding {
+		ccollectionID := C.ostree_repo_get_collection_id(repo.native())
+		if ccollectionID == nil {
+			if cmetadata != nil {
+				C.g_variant_unref(cmetadata)
+			}
+			return nil, errors.New("Collection binding requires the repo to have a collection ID")
+		}
+		cmetadata = addCollectionBinding(cmetadata, C.GoString((*C.char)(ccollectionID)), branch)
+	}
+	var metadata *glib.GVariant
+	if cmetadata != nil {
+		defer C.g_variant_unref(cmetadata)
+		metadata = glib.ToGVariant(unsafe.Pointer(cmetadata))
+	}
+
+	result.Checksum, err = repo.WriteCommitObject(CommitObject{
+		Parent:    result.Parent,
+		Subject:   opts.Subject,
+		Body:      opts.Body,
+		Metadata:  metadata,
+		Timestamp: opts.Timestamp,
+		RootTree:  result.RootTree,
+		RootMeta:  root.MetadataChecksum(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Orphan commits without a branch are only reachable by checksum
+	if branch != "" {
+		repo.TransactionSetRef("", branch, result.Checksum)
+	}
+	return result, nil
+}
+
+// fsMetadata returns the metadata to commit for the entry name of fsys
+func fsMetadata(fsys fs.FS, name string, info fs.FileInfo, opts commitOptions) (FileInfo, map[string][]byte, error) {
+	mode := info.Mode()
+	meta := FileInfo{Mode: uint32(mode.Perm())}
+	if mode&fs.ModeSetuid != 0 {
+		meta.Mode |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		meta.Mode |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		meta.Mode |= 01000
+	}
+	if opts.OwnerUID >= 0 {
+		meta.UID = uint32(opts.OwnerUID)
+	}
+	if opts.OwnerGID >= 0 {
+		meta.GID = uint32(opts.OwnerGID)
+	}
+
+	switch mode.Type() {
+	case fs.ModeDir:
+		meta.Mode |= modeTypeDir
+	case fs.ModeSymlink:
+		meta.Mode |= modeTypeSymlink
+		if rfs, ok := fsys.(readLinkFS); ok {
+			target, err := rfs.ReadLink(name)
+			if err != nil {
+				return meta, nil, err
+			}
+			meta.SymlinkTarget = target
+		}
+	case 0:
+		meta.Mode |= modeTypeRegular
+		meta.Size = info.Size()
+	}
+
+	var xattrs map[string][]byte
+	if opts.FSMetadata != nil {
+		var err error
+		if meta, xattrs, err = opts.FSMetadata(name, info, meta); err != nil {
+			return meta, nil, err
+		}
+	}
+	if opts.NoXattrs {
+		xattrs = nil
+	}
+	if opts.XattrCallback != nil {
+		if replaced := opts.XattrCallback(path.Join("/", name)); replaced != nil {
+			xattrs = replaced
+		}
+	}
+	if meta.Mode&modeTypeMask == modeTypeSymlink && meta.SymlinkTarget == "" {
+		return meta, nil, fmt.Errorf("%s: cannot read symbolic link target", name)
+	}
+	return meta, xattrs, nil
+}
+
+// resolveCommitParent returns the checksum of the parent of a new commit on
+// branch: opts.Parent if set, unless it is "none", or else the current
+// commit of the branch, if any
+func (repo *Repo) resolveCommitParent(branch string, opts commitOptions) (string, error) {
+	switch {
+	case opts.Parent == "none":
+		return "", nil
+	case opts.Parent != "":
+		return repo.ResolveRev(opts.Parent, false)
+	case opts.Orphan || branch == "":
+		return "", nil
+	default:
+		return repo.ResolveRev(branch, true)
+	}
+}
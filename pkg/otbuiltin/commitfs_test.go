@@ -0,0 +1,251 @@
+package otbuiltin
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCommitFS(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// Archive repos store ownership and xattrs in the objects themselves
+	initOpts := NewInitOptions()
+	initOpts.Mode = "archive-z2"
+	repo, err := CreateRepoAt(syscall.AT_FDCWD, baseDir, initOpts)
+	if err != nil {
+		t.Fatalf("failed to create repo: %s", err)
+	}
+	defer repo.Close()
+
+	fsys := fstest.MapFS{
+		"etc/motd":     {Data: []byte("welcome\n"), Mode: 0600},
+		"usr/bin/tool": {Data: []byte("#!/bin/sh\n"), Mode: 0755 | fs.ModeSetuid},
+		"usr/bin/link": {Data: []byte("tool"), Mode: fs.ModeSymlink | 0777},
+	}
+
+	opts := NewCommitOptions()
+	opts.Subject = "from fs"
+	opts.OwnerUID = 1000
+	opts.OwnerGID = 1000
+	opts.FSMetadata = func(name string, info fs.FileInfo, meta FileInfo) (FileInfo, map[string][]byte, error) {
+		if name == "usr/bin/tool" {
+			meta.UID = 0
+			meta.GID = 0
+			return meta, map[string][]byte{"user.test": []byte("value")}, nil
+		}
+		return meta, nil, nil
+	}
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	result, err := repo.CommitFS(fsys, "fs-branch", opts)
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+	if result.Stats != nil {
+		t.Errorf("unexpected transaction stats %+v within a transaction", result.Stats)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := repo.Export(result.Checksum, &buf, NewExportOptions()); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	headers, contents := readTar(t, &buf)
+
+	if contents["etc/motd"] != "welcome\n" {
+		t.Errorf("unexpected content %q", contents["etc/motd"])
+	}
+	if hdr := headers["etc/motd"]; hdr == nil || hdr.Mode != 0600 || hdr.Uid != 1000 || hdr.Gid != 1000 {
+		t.Errorf("unexpected entry %+v", hdr)
+	}
+	if hdr := headers["usr/bin/tool"]; hdr == nil || hdr.Mode != 04755 || hdr.Uid != 0 || hdr.PAXRecords["SCHILY.xattr.user.test"] != "value" {
+		t.Errorf("unexpected entry %+v", hdr)
+	}
+	if hdr := headers["usr/bin/link"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "tool" {
+		t.Errorf("unexpected entry %+v", hdr)
+	}
+	if hdr := headers["usr/"]; hdr == nil || hdr.Typeflag != tar.TypeDir {
+		t.Errorf("unexpected entry %+v", hdr)
+	}
+
+	// A second commit on the branch gets the first one as parent
+	tx, err = repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	parent, err := repo.resolveCommitParent("fs-branch", NewCommitOptions())
+	if err != nil {
+		t.Fatalf("failed to resolve parent: %s", err)
+	}
+	if parent != result.Checksum {
+		t.Errorf("expected parent %s, got %s", result.Checksum, parent)
+	}
+	if _, err := repo.CommitFS(fsys, "", NewCommitOptions()); err == nil {
+		t.Error("got unexpected nil error committing without branch")
+	}
+}
+
+func TestCommitFSStatOverrides(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	initOpts := NewInitOptions()
+	initOpts.Mode = "archive-z2"
+	repo, err := CreateRepoAt(syscall.AT_FDCWD, baseDir, initOpts)
+	if err != nil {
+		t.Fatalf("failed to create repo: %s", err)
+	}
+	defer repo.Close()
+
+	fsys := fstest.MapFS{
+		"etc/shadow":   {Data: []byte("root:*\n"), Mode: 0644},
+		"usr/bin/su":   {Data: []byte("#!/bin/sh\n"), Mode: 0755},
+		"usr/bin/tool": {Data: []byte("#!/bin/sh\n"), Mode: 0755 | fs.ModeSetuid},
+	}
+
+	overrideFile := path.Join(baseDir, "overrides")
+	if err := ioutil.WriteFile(overrideFile, []byte("0600 /etc/shadow\n+04000 uid=0 /usr/bin/su\n"), 0644); err != nil {
+		t.Fatalf("failed to write stat overrides: %s", err)
+	}
+	chown := NewStatOverride("/usr/bin/tool")
+	chown.UID = 1000
+	opts := NewCommitOptions()
+	opts.OwnerUID = 0
+	opts.OwnerGID = 0
+	opts.StatOverrideFile = overrideFile
+	opts.StatOverrides = []StatOverride{chown}
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	result, err := repo.CommitFS(fsys, "overrides", opts)
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+
+	// Overrides which match nothing are errors
+	unmatched := NewStatOverride("/nonexistent")
+	unmatched.Mode = 0644
+	opts.StatOverrides = append(opts.StatOverrides, unmatched)
+	if _, err := repo.CommitFS(fsys, "overrides", opts); err == nil {
+		t.Error("got unexpected nil error with an unmatched stat override")
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := repo.Export(result.Checksum, &buf, NewExportOptions()); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	headers, _ := readTar(t, &buf)
+	for name, expected := range map[string]struct {
+		mode int64
+		uid  int
+	}{
+		"etc/shadow":   {0600, 0},
+		"usr/bin/su":   {04755, 0},
+		"usr/bin/tool": {0755, 1000},
+	} {
+		if hdr := headers[name]; hdr == nil || hdr.Mode != expected.mode || hdr.Uid != expected.uid {
+			t.Errorf("unexpected entry %+v, expected mode %o and uid %d", hdr, expected.mode, expected.uid)
+		}
+	}
+}
+
+func TestCommitFSResult(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	// Without a transaction, CommitFS runs its own
+	fsys := fstest.MapFS{"file": {Data: []byte("first"), Mode: 0644}}
+	first, err := repo.CommitFS(fsys, "main", NewCommitOptions())
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+	if len(first.Checksum) != 64 || len(first.RootTree) != 64 || first.Parent != "" || first.Skipped {
+		t.Errorf("unexpected first commit %+v", first)
+	}
+	if first.Stats == nil || first.Stats.ContentObjectsWritten != 1 {
+		t.Errorf("unexpected transaction stats %+v", first.Stats)
+	}
+	if rev, err := repo.ResolveRev("main", false); err != nil || rev != first.Checksum {
+		t.Errorf("expected main to be %s, got %s: %v", first.Checksum, rev, err)
+	}
+
+	// Unchanged trees are skipped, reporting the parent
+	opts := NewCommitOptions()
+	opts.SkipIfUnchanged = true
+	skipped, err := repo.CommitFS(fsys, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+	if !skipped.Skipped || skipped.Checksum != first.Checksum || skipped.Parent != first.Checksum || skipped.RootTree != first.RootTree {
+		t.Errorf("unexpected skipped commit %+v", skipped)
+	}
+
+	fsys["file"].Data = []byte("second")
+	second, err := repo.CommitFS(fsys, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+	if second.Skipped || second.Checksum == first.Checksum || second.Parent != first.Checksum {
+		t.Errorf("unexpected second commit %+v", second)
+	}
+
+	// Orphan commits don't take the branch as parent, but still set it
+	opts = NewCommitOptions()
+	opts.Orphan = true
+	opts.Subject = "orphan"
+	orphan, err := repo.CommitFS(fsys, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit orphan: %s", err)
+	}
+	if len(orphan.Checksum) != 64 || orphan.Parent != "" {
+		t.Errorf("unexpected orphan commit %+v", orphan)
+	}
+	refs, err := repo.ListRefs("")
+	if err != nil {
+		t.Fatalf("failed to list refs: %s", err)
+	}
+	if len(refs) != 1 || refs["main"] != orphan.Checksum {
+		t.Errorf("unexpected refs %v", refs)
+	}
+
+	// Without a branch, they set no ref
+	if _, err := repo.CommitFS(fsys, "", opts); err != nil {
+		t.Fatalf("failed to commit orphan without branch: %s", err)
+	}
+	if refs, err := repo.ListRefs(""); err != nil || len(refs) != 1 {
+		t.Errorf("unexpected refs %v: %v", refs, err)
+	}
+}
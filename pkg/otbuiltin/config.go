@@ -0,0 +1,253 @@
+package otbuiltin
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// RepoConfig is the typed view of the [core] section of a repo's config file.
+//
+// Fields which ostree leaves unset by default use a sentinel value (empty
+// string or -1) so that WriteConfig only writes the keys that are set.
+// Boolean fields hold ostree's default when unset, and WriteConfig only
+// writes them when they are already in the config file or differ from that
+// default.
+type RepoConfig struct {
+	// Mode is the repository mode (core.mode), e.g. bare or archive-z2
+	Mode string
+	// MinFreeSpacePercent is core.min-free-space-percent, -1 if unset
+	MinFreeSpacePercent int
+	// MinFreeSpaceSize is core.min-free-space-size, e.g. "500MB", empty if unset
+	MinFreeSpaceSize string
+	// Fsync is core.fsync
+	Fsync bool
+	// PerObjectFsync is core.per-object-fsync
+	PerObjectFsync bool
+	// TombstoneCommits is core.tombstone-commits
+	TombstoneCommits bool
+	// Locking is core.locking
+	Locking bool
+	// CollectionID is core.collection-id, empty if unset
+	CollectionID string
+	// Parent is the path of the parent repo (core.parent), empty if unset
+	Parent string
+	// PayloadLinkThreshold is core.payload-link-threshold in bytes, -1 if unset
+	PayloadLinkThreshold int64
+}
+
+// Config returns the typed configuration of the repo, with ostree's
+// defaults filled in for the keys which are not set
+func (r *Repo) Config() (*RepoConfig, error) {
+	if !r.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	config := C.ostree_repo_get_config(r.native())
+	cfg := &RepoConfig{
+		MinFreeSpacePercent:  -1,
+		PayloadLinkThreshold: -1,
+	}
+
+	var err error
+	cfg.Mode, _ = keyFileGet(config, "core", "mode")
+	cfg.MinFreeSpaceSize, _ = keyFileGet(config, "core", "min-free-space-size")
+	cfg.CollectionID, _ = keyFileGet(config, "core", "collection-id")
+	cfg.Parent, _ = keyFileGet(config, "core", "parent")
+	if value, ok := keyFileGet(config, "core", "min-free-space-percent"); ok {
+		if cfg.MinFreeSpacePercent, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid core.min-free-space-percent %q", value)
+		}
+	}
+	if value, ok := keyFileGet(config, "core", "payload-link-threshold"); ok {
+		if cfg.PayloadLinkThreshold, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid core.payload-link-threshold %q", value)
+		}
+	}
+
+	for _, b := range cfg.bools() {
+		*b.value = b.defaultValue
+		if value, ok := keyFileGet(config, "core", b.key); ok {
+			if *b.value, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid core.%s %q", b.key, value)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// WriteConfig writes cfg to the repo's config file and reloads it.  Keys
+// of the [core] section not covered by cfg, and all other sections, are
+// preserved.  The mode of an existing repo cannot be changed.
+func (r *Repo) WriteConfig(cfg *RepoConfig) error {
+	if !r.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	current, err := r.Config()
+	if err != nil {
+		return err
+	}
+	if cfg.Mode != "" && cfg.Mode != current.Mode {
+		newMode, err := parseRepoMode(cfg.Mode)
+		if err != nil {
+			return err
+		}
+		if newMode != C.ostree_repo_get_mode(r.native()) {
+			return fmt.Errorf("cannot change repo mode from %s to %s", current.Mode, cfg.Mode)
+		}
+	}
+	if cfg.MinFreeSpacePercent >= 0 && cfg.MinFreeSpaceSize != "" {
+		return errors.New("min-free-space-percent and min-free-space-size are mutually exclusive")
+	}
+
+	config := C.ostree_repo_copy_config(r.native())
+	defer C.g_key_file_unref(config)
+
+	if cfg.Mode != "" {
+		keyFileSet(config, "core", "mode", cfg.Mode)
+	}
+	keyFileSetOrRemove(config, "core", "min-free-space-size", cfg.MinFreeSpaceSize)
+	keyFileSetOrRemove(config, "core", "collection-id", cfg.CollectionID)
+	keyFileSetOrRemove(config, "core", "parent", cfg.Parent)
+	if cfg.MinFreeSpacePercent >= 0 {
+		keyFileSet(config, "core", "min-free-space-percent", strconv.Itoa(cfg.MinFreeSpacePercent))
+	} else {
+		keyFileSetOrRemove(config, "core", "min-free-space-percent", "")
+	}
+	if cfg.PayloadLinkThreshold >= 0 {
+		keyFileSet(config, "core", "payload-link-threshold", strconv.FormatInt(cfg.PayloadLinkThreshold, 10))
+	} else {
+		keyFileSetOrRemove(config, "core", "payload-link-threshold", "")
+	}
+	for _, b := range cfg.bools() {
+		if _, ok := keyFileGet(config, "core", b.key); ok || *b.value != b.defaultValue {
+			keyFileSet(config, "core", b.key, strconv.FormatBool(*b.value))
+		}
+	}
+
+	return r.writeConfig(config)
+}
+
+// repoConfigBool is a boolean key of the [core] section
+type repoConfigBool struct {
+	key          string
+	value        *bool
+	defaultValue bool
+}
+
+// bools returns the boolean keys of cfg with ostree's defaults
+func (cfg *RepoConfig) bools() []repoConfigBool {
+	return []repoConfigBool{
+		{"fsync", &cfg.Fsync, true},
+		{"per-object-fsync", &cfg.PerObjectFsync, false},
+		{"tombstone-commits", &cfg.TombstoneCommits, false},
+		{"locking", &cfg.Locking, true},
+	}
+}
+
+// ReloadConfig re-reads the repo's config file from disk
+func (r *Repo) ReloadConfig() error {
+	if !r.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_reload_config(r.native(), nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// ConfigGet returns the raw value of key in section of the repo's config,
+// e.g. ConfigGet("remote \"origin\"", "url")
+func (r *Repo) ConfigGet(section, key string) (string, error) {
+	if !r.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	value, ok := keyFileGet(C.ostree_repo_get_config(r.native()), section, key)
+	if !ok {
+		return "", fmt.Errorf("no key %s in section %s", key, section)
+	}
+	return value, nil
+}
+
+// ConfigSet sets key in section of the repo's config to the raw value,
+// writes the config file and reloads it
+func (r *Repo) ConfigSet(section, key, value string) error {
+	if !r.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(r)
+
+	config := C.ostree_repo_copy_config(r.native())
+	defer C.g_key_file_unref(config)
+	keyFileSet(config, section, key, value)
+	return r.writeConfig(config)
+}
+
+// writeConfig replaces the repo's config with the given key file
+func (r *Repo) writeConfig(config *C.GKeyFile) error {
+	defer runtime.KeepAlive(r)
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_write_config(r.native(), config, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// keyFileGet returns the raw value of key in section, and whether it is set
+func keyFileGet(config *C.GKeyFile, section, key string) (string, bool) {
+	csection := C.CString(section)
+	defer C.free(unsafe.Pointer(csection))
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	cvalue := C.g_key_file_get_value(config, (*C.gchar)(csection), (*C.gchar)(ckey), nil)
+	if cvalue == nil {
+		return "", false
+	}
+	defer C.g_free(C.gpointer(cvalue))
+	return C.GoString((*C.char)(cvalue)), true
+}
+
+// keyFileSet sets key in section to the raw value
+func keyFileSet(config *C.GKeyFile, section, key, value string) {
+	csection := C.CString(section)
+	defer C.free(unsafe.Pointer(csection))
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	C.g_key_file_set_value(config, (*C.gchar)(csection), (*C.gchar)(ckey), (*C.gchar)(cvalue))
+}
+
+// keyFileSetOrRemove sets key in section to value, or removes the key if
+// value is empty
+func keyFileSetOrRemove(config *C.GKeyFile, section, key, value string) {
+	if value != "" {
+		keyFileSet(config, section, key, value)
+		return
+	}
+
+	csection := C.CString(section)
+	defer C.free(unsafe.Pointer(csection))
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	C.g_key_file_remove_key(config, (*C.gchar)(csection), (*C.gchar)(ckey), nil)
+}
@@ -0,0 +1,113 @@
+package otbuiltin
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.Mkdir(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	if _, err := Init(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if cfg.Mode != "bare" || !cfg.Fsync || cfg.TombstoneCommits || cfg.MinFreeSpacePercent != -1 || cfg.PayloadLinkThreshold != -1 {
+		t.Errorf("unexpected default config %+v", cfg)
+	}
+
+	cfg.TombstoneCommits = true
+	cfg.MinFreeSpaceSize = "500MB"
+	cfg.CollectionID = "org.example.Os"
+	if err := repo.WriteConfig(cfg); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	// Reopen the repo to make sure the config hit the disk
+	repo, err = OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to reopen repo at %q: %s", repoDir, err)
+	}
+	written, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if *written != *cfg {
+		t.Errorf("expected %+v, got %+v", cfg, written)
+	}
+	if value, err := repo.ConfigGet("core", "tombstone-commits"); err != nil || value != "true" {
+		t.Errorf("expected core.tombstone-commits to be written, got %q: %v", value, err)
+	}
+	for _, key := range []string{"fsync", "per-object-fsync", "locking"} {
+		if value, err := repo.ConfigGet("core", key); err == nil {
+			t.Errorf("expected core.%s to keep its default, got %q", key, value)
+		}
+	}
+
+	cfg.Mode = "archive-z2"
+	if err := repo.WriteConfig(cfg); err == nil {
+		t.Error("got unexpected nil error changing the repo mode")
+	}
+	cfg.Mode = "bare"
+	cfg.MinFreeSpacePercent = 3
+	if err := repo.WriteConfig(cfg); err == nil {
+		t.Error("got unexpected nil error setting both min-free-space keys")
+	}
+}
+
+func TestConfigGetSet(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.Mkdir(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	if _, err := Init(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	if _, err := repo.ConfigGet("remote \"origin\"", "url"); err == nil {
+		t.Error("got unexpected nil error for a missing key")
+	}
+	if err := repo.ConfigSet("remote \"origin\"", "url", "https://example.com/repo"); err != nil {
+		t.Fatalf("failed to set key: %s", err)
+	}
+	if err := repo.ReloadConfig(); err != nil {
+		t.Fatalf("failed to reload config: %s", err)
+	}
+	value, err := repo.ConfigGet("remote \"origin\"", "url")
+	if err != nil {
+		t.Fatalf("failed to get key: %s", err)
+	}
+	if value != "https://example.com/repo" {
+		t.Errorf("unexpected value %q", value)
+	}
+}
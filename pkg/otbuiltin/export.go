@@ -0,0 +1,298 @@
+package otbuiltin
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// exportOptions contains all of the options for exporting a commit as a tar
+// stream
+//
+// Note: while this is private, fields are public and part of the API.
+type exportOptions struct {
+	// Subpath specifies a sub-directory of the commit to export instead of the root
+	Subpath string
+	// Prefix is prepended to the path of every entry in the archive
+	Prefix string
+	// NoXattrs defines whether to skip exporting extended attributes
+	NoXattrs bool
+}
+
+// NewExportOptions instantiates and returns an exportOptions struct with default values set
+func NewExportOptions() exportOptions {
+	return exportOptions{}
+}
+
+// exporter holds the state of a single export
+type exporter struct {
+	repo    *Repo
+	tw      *tar.Writer
+	opts    exportOptions
+	modTime time.Time
+	// links maps content checksums to the first path they were written at
+	links map[string]string
+}
+
+// Export writes the tree of commit `rev` to w as a tar stream, like
+// `ostree export`.  Files sharing the same content object are written once
+// and hardlinked, and extended attributes are stored as PAX records.  All
+// entries get the timestamp of the commit.
+func (repo *Repo) Export(rev string, w io.Writer, opts exportOptions) error {
+	if !repo.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	crev := C.CString(rev)
+	defer C.free(unsafe.Pointer(crev))
+
+	var cerr *C.GError
+	var root *C.GFile
+	var checksum *C.char
+	if !isOk(C.ostree_repo_read_commit(repo.native(), crev, &root, &checksum, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(root))
+	defer C.g_free(C.gpointer(checksum))
+
+	var commit *C.GVariant
+	if !isOk(C.ostree_repo_load_variant(repo.native(), C.OSTREE_OBJECT_TYPE_COMMIT, checksum, &commit, &cerr)) {
+		return generateError(cerr)
+	}
+	timestamp := C.ostree_commit_get_timestamp(commit)
+	C.g_variant_unref(commit)
+
+	start := root
+	if subpath := strings.Trim(opts.Subpath, "/"); subpath != "" {
+		csubpath := C.CString(subpath)
+		defer C.free(unsafe.Pointer(csubpath))
+		start = C.g_file_resolve_relative_path(root, csubpath)
+		defer C.g_object_unref(C.gpointer(start))
+	}
+
+	e := &exporter{
+		repo:    repo,
+		tw:      tar.NewWriter(w),
+		opts:    opts,
+		modTime: time.Unix(int64(timestamp), 0),
+		links:   make(map[string]string),
+	}
+	if err := e.writeFile(start, strings.Trim(opts.Prefix, "/")); err != nil {
+		return err
+	}
+	return e.tw.Close()
+}
+
+// writeFile writes the given file of the commit, and its children if it is
+// a directory, under the archive path name
+func (e *exporter) writeFile(file *C.GFile, name string) error {
+	var cerr *C.GError
+	repoFile := C._ostree_repo_file(file)
+	if !isOk(C.ostree_repo_file_ensure_resolved(repoFile, &cerr)) {
+		return generateError(cerr)
+	}
+
+	cattrs := C.CString("standard::name,standard::type,standard::size,standard::symlink-target,unix::mode,unix::uid,unix::gid")
+	defer C.free(unsafe.Pointer(cattrs))
+	info := C.g_file_query_info(file, cattrs, C.G_FILE_QUERY_INFO_NOFOLLOW_SYMLINKS, nil, &cerr)
+	if info == nil {
+		return generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(info))
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(fileInfoUint32(info, "unix::mode") & 07777),
+		Uid:     int(fileInfoUint32(info, "unix::uid")),
+		Gid:     int(fileInfoUint32(info, "unix::gid")),
+		ModTime: e.modTime,
+	}
+
+	if !e.opts.NoXattrs {
+		var xattrs *C.GVariant
+		if !isOk(C.ostree_repo_file_get_xattrs(repoFile, &xattrs, nil, &cerr)) {
+			return generateError(cerr)
+		}
+		if xattrs != nil {
+			for key, value := range xattrsFromVariant(xattrs) {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = make(map[string]string)
+				}
+				hdr.PAXRecords["SCHILY.xattr."+key] = string(value)
+			}
+			C.g_variant_unref(xattrs)
+		}
+		if hdr.PAXRecords != nil {
+			hdr.Format = tar.FormatPAX
+		}
+	}
+
+	fileType := C.g_file_info_get_file_type(info)
+	if fileType == C.G_FILE_TYPE_DIRECTORY {
+		hdr.Typeflag = tar.TypeDir
+		if name == "" {
+			hdr.Name = "./"
+		} else {
+			hdr.Name = name + "/"
+		}
+		if err := e.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return e.writeChildren(file, name)
+	}
+
+	// A non-directory subpath exported without prefix is named after itself
+	if name == "" {
+		name = C.GoString(C.g_file_info_get_name(info))
+		hdr.Name = name
+	}
+
+	switch fileType {
+	case C.G_FILE_TYPE_SYMBOLIC_LINK:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = C.GoString(C.g_file_info_get_symlink_target(info))
+		return e.tw.WriteHeader(hdr)
+	case C.G_FILE_TYPE_REGULAR:
+		checksum := C.GoString(C.ostree_repo_file_get_checksum(repoFile))
+		if target, ok := e.links[checksum]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = target
+			return e.tw.WriteHeader(hdr)
+		}
+		e.links[checksum] = name
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(C.g_file_info_get_size(info))
+		if err := e.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return e.writeContent(checksum)
+	default:
+		return errors.New("unsupported file type at " + name)
+	}
+}
+
+// writeChildren writes the children of a directory, sorted by name
+func (e *exporter) writeChildren(dir *C.GFile, name string) error {
+	var cerr *C.GError
+	cattrs := C.CString("standard::name")
+	defer C.free(unsafe.Pointer(cattrs))
+	enumerator := C.g_file_enumerate_children(dir, cattrs, C.G_FILE_QUERY_INFO_NOFOLLOW_SYMLINKS, nil, &cerr)
+	if enumerator == nil {
+		return generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(enumerator))
+
+	var names []string
+	for {
+		info := C.g_file_enumerator_next_file(enumerator, nil, &cerr)
+		if info == nil {
+			if cerr != nil {
+				return generateError(cerr)
+			}
+			break
+		}
+		names = append(names, C.GoString(C.g_file_info_get_name(info)))
+		C.g_object_unref(C.gpointer(info))
+	}
+	sort.Strings(names)
+
+	for _, childName := range names {
+		cchildName := C.CString(childName)
+		child := C.g_file_get_child(dir, cchildName)
+		C.free(unsafe.Pointer(cchildName))
+
+		err := e.writeFile(child, path.Join(name, childName))
+		C.g_object_unref(C.gpointer(child))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeContent copies the content of a regular file object to the archive
+func (e *exporter) writeContent(checksum string) error {
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+
+	var cerr *C.GError
+	var stream *C.GInputStream
+	if !isOk(C.ostree_repo_load_file(e.repo.native(), cchecksum, &stream, nil, nil, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(stream))
+
+	_, err := io.Copy(e.tw, &inputStreamReader{stream})
+	return err
+}
+
+// inputStreamReader adapts a GInputStream to an io.Reader
+type inputStreamReader struct {
+	stream *C.GInputStream
+}
+
+// Read implements io.Reader
+func (r *inputStreamReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var cerr *C.GError
+	n := C.g_input_stream_read(r.stream, unsafe.Pointer(&p[0]), C.gsize(len(p)), nil, &cerr)
+	if n < 0 {
+		return 0, generateError(cerr)
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+// fileInfoUint32 returns the value of a uint32 attribute of a GFileInfo
+func fileInfoUint32(info *C.GFileInfo, attribute string) uint32 {
+	cattribute := C.CString(attribute)
+	defer C.free(unsafe.Pointer(cattribute))
+	return uint32(C.g_file_info_get_attribute_uint32(info, cattribute))
+}
+
+// setFileInfoUint32 sets a uint32 attribute of a GFileInfo
+func setFileInfoUint32(info *C.GFileInfo, attribute string, value uint32) {
+	cattribute := C.CString(attribute)
+	defer C.free(unsafe.Pointer(cattribute))
+	C.g_file_info_set_attribute_uint32(info, cattribute, C.guint32(value))
+}
+
+// xattrsFromVariant converts an ostree a(ayay) extended attributes variant
+// to a map of attribute names to values
+func xattrsFromVariant(variant *C.GVariant) map[string][]byte {
+	n := C.g_variant_n_children(variant)
+	xattrs := make(map[string][]byte, int(n))
+	for i := C.gsize(0); i < n; i++ {
+		child := C.g_variant_get_child_value(variant, i)
+		nameVariant := C.g_variant_get_child_value(child, 0)
+		valueVariant := C.g_variant_get_child_value(child, 1)
+
+		name := C.GoString((*C.char)(C.g_variant_get_bytestring(nameVariant)))
+		xattrs[name] = C.GoBytes(unsafe.Pointer(C.g_variant_get_data(valueVariant)), C.int(C.g_variant_get_size(valueVariant)))
+
+		C.g_variant_unref(valueVariant)
+		C.g_variant_unref(nameVariant)
+		C.g_variant_unref(child)
+	}
+	return xattrs
+}
@@ -0,0 +1,160 @@
+package otbuiltin
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// readTar reads all entries of a tar stream, returning their headers and
+// the contents of regular files, keyed by name
+func readTar(t *testing.T, r io.Reader) (map[string]*tar.Header, map[string]string) {
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar stream: %s", err)
+		}
+		headers[hdr.Name] = hdr
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("failed to read %q: %s", hdr.Name, err)
+			}
+			contents[hdr.Name] = string(data)
+		}
+	}
+	return headers, contents
+}
+
+func TestExportSuccess(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// Make a directory in which the repo should exist
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.Mkdir(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+
+	// Initialize the repo
+	inited, err := Init(repoDir, NewInitOptions())
+	if !inited || err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+
+	// Make a tree with two identical files and a symlink
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.MkdirAll(path.Join(commitDir, "sub"), 0755); err != nil {
+		t.Fatalf("failed to make commit dir: %s", err)
+	}
+	for _, file := range []string{"a.txt", "sub/b.txt"} {
+		if err := ioutil.WriteFile(path.Join(commitDir, file), []byte("hello"), 0644); err != nil {
+			t.Fatalf("failed to write %q: %s", file, err)
+		}
+	}
+	if err := os.Symlink("a.txt", path.Join(commitDir, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	branch := "test-branch"
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	if _, err := repo.Commit(commitDir, branch, NewCommitOptions()); err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	// Export the whole commit
+	var buf bytes.Buffer
+	if err := repo.Export(branch, &buf, NewExportOptions()); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	headers, contents := readTar(t, &buf)
+
+	for _, name := range []string{"./", "a.txt", "link", "sub/", "sub/b.txt"} {
+		if _, ok := headers[name]; !ok {
+			t.Errorf("missing entry %q", name)
+		}
+	}
+	if len(headers) != 5 {
+		t.Errorf("expected 5 entries, got %d", len(headers))
+	}
+	if contents["a.txt"] != "hello" {
+		t.Errorf("unexpected content %q", contents["a.txt"])
+	}
+	if hdr := headers["sub/b.txt"]; hdr != nil && (hdr.Typeflag != tar.TypeLink || hdr.Linkname != "a.txt") {
+		t.Errorf("expected sub/b.txt to be a hardlink to a.txt, got type %c to %q", hdr.Typeflag, hdr.Linkname)
+	}
+	if hdr := headers["link"]; hdr != nil && (hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "a.txt") {
+		t.Errorf("expected link to be a symlink to a.txt, got type %c to %q", hdr.Typeflag, hdr.Linkname)
+	}
+	if hdr := headers["a.txt"]; hdr != nil && hdr.Mode != 0644 {
+		t.Errorf("expected mode 0644, got %o", hdr.Mode)
+	}
+
+	// Export a subpath under a prefix
+	buf.Reset()
+	exportOpts := NewExportOptions()
+	exportOpts.Subpath = "/sub"
+	exportOpts.Prefix = "export/"
+	if err := repo.Export(branch, &buf, exportOpts); err != nil {
+		t.Fatalf("failed to export subpath: %s", err)
+	}
+	headers, contents = readTar(t, &buf)
+	if len(headers) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(headers))
+	}
+	if _, ok := headers["export/"]; !ok {
+		t.Error("missing entry \"export/\"")
+	}
+	if contents["export/b.txt"] != "hello" {
+		t.Errorf("unexpected content %q", contents["export/b.txt"])
+	}
+}
+
+func TestExportFail(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if err := os.Mkdir(repoDir, 0777); err != nil {
+		t.Fatalf("failed to create repodir at %q: %s", repoDir, err)
+	}
+	if _, err := Init(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+
+	var buf bytes.Buffer
+	if err := repo.Export("no-such-branch", &buf, NewExportOptions()); err == nil {
+		t.Fatal("got unexpected nil error exporting a missing branch")
+	}
+}
@@ -1,6 +1,9 @@
 package otbuiltin
 
 import (
+	"errors"
+	"fmt"
+	"runtime"
 	"strings"
 	"unsafe"
 )
@@ -12,12 +15,22 @@ import (
 // #include "builtin.go.h"
 import "C"
 
+// ErrRepoExists is returned when initializing a repo at a path which
+// already holds one
+var ErrRepoExists = errors.New("repository already exists")
+
 // initOptions contains all of the options for initializing an ostree repo
 //
 // Note: while this is private, exported fields are public and part of the API.
 type initOptions struct {
-	// Mode defines repository mode: either bare, archive-z2, or bare-user
+	// Mode defines repository mode: either bare, archive-z2, bare-user,
+	// bare-user-only or bare-split-xattrs
 	Mode string
+	// CollectionID is the collection ID of the repo, if any
+	CollectionID string
+	// Config holds initial config values keyed by "section.key", e.g.
+	// "core.min-free-space-size", which override the defaults
+	Config map[string]string
 }
 
 // NewInitOptions instantiates and returns an initOptions struct with default values set
@@ -27,14 +40,36 @@ func NewInitOptions() initOptions {
 	}
 }
 
+// InitResult describes the outcome of InitRepo
+type InitResult struct {
+	// Created is true if the repo was created, false if it already existed
+	Created bool
+	// Mode is the actual mode of the repo
+	Mode string
+}
+
 // Init initializes a new ostree repository at the given path.  Returns true
 // if the repo exists at the location, regardless of whether it was initialized
 // by the function or if it already existed.  Returns an error if the repo could
-// not be initialized
+// not be initialized.  Use InitRepo to tell whether the repo already existed.
 func Init(path string, options initOptions) (bool, error) {
+	if _, err := InitRepo(path, options); err != nil && err != ErrRepoExists {
+		return false, err
+	}
+	return true, nil
+}
+
+// InitRepo initializes a new ostree repository at the given path.  If a repo
+// already exists there, it is left untouched and ErrRepoExists is returned
+// along with a result describing the existing repo.
+func InitRepo(path string, options initOptions) (*InitResult, error) {
 	repoMode, err := parseRepoMode(options.Mode)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	config, err := parseInitConfig(options.Config)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create a repo struct from the path
@@ -42,28 +77,66 @@ func Init(path string, options initOptions) (bool, error) {
 	defer C.free(unsafe.Pointer(cpath))
 	pathc := C.g_file_new_for_path(cpath)
 	defer C.g_object_unref(C.gpointer(pathc))
-	repo := C.ostree_repo_new(pathc)
-
-	// If the repo exists in the filesystem, return an error but set exists to true
-	/* var exists C.gboolean = 0
-	success := glib.GoBool(glib.GBoolean(C.ostree_repo_exists(crepo, &exists, &cerr)))
-	if exists != 0 {
-	  err = errors.New("repository already exists")
-	  return true, err
-	} else if !success {
-	  return false, generateError(cerr)
-	}*/
+	crepo := C.ostree_repo_new(pathc)
+	defer C.g_object_unref(C.gpointer(crepo))
+	repo := repoFromNative(crepo)
 
+	// If a repo can be opened at the path, it already exists
 	var cErr *C.GError
-	defer C.free(unsafe.Pointer(cErr))
-	if r := C.ostree_repo_create(repo, repoMode, nil, &cErr); !isOk(r) {
-		err := generateError(cErr)
-		if strings.Contains(err.Error(), "File exists") {
-			return true, err
+	if isOk(C.ostree_repo_open(crepo, nil, &cErr)) {
+		mode, _ := keyFileGet(C.ostree_repo_get_config(crepo), "core", "mode")
+		return &InitResult{Created: false, Mode: mode}, ErrRepoExists
+	}
+	C.g_clear_error(&cErr)
+
+	if options.CollectionID != "" {
+		ccollectionID := C.CString(options.CollectionID)
+		defer C.free(unsafe.Pointer(ccollectionID))
+		if !isOk(C.ostree_repo_set_collection_id(crepo, (*C.gchar)(ccollectionID), &cErr)) {
+			return nil, generateError(cErr)
 		}
-		return false, err
 	}
-	return true, nil
+
+	if !isOk(C.ostree_repo_create(crepo, repoMode, nil, &cErr)) {
+		return nil, generateError(cErr)
+	}
+
+	if err := repo.writeInitConfig(config); err != nil {
+		return nil, err
+	}
+
+	mode, _ := keyFileGet(C.ostree_repo_get_config(crepo), "core", "mode")
+	return &InitResult{Created: true, Mode: mode}, nil
+}
+
+// parseInitConfig splits the "section.key" names of initial config values
+// into section and key, at the last dot
+func parseInitConfig(config map[string]string) (map[[2]string]string, error) {
+	parsed := make(map[[2]string]string, len(config))
+	for name, value := range config {
+		dot := strings.LastIndex(name, ".")
+		if dot <= 0 || dot == len(name)-1 {
+			return nil, fmt.Errorf("invalid config key %q, expected section.key", name)
+		}
+		parsed[[2]string{name[:dot], name[dot+1:]}] = value
+	}
+	return parsed, nil
+}
+
+// writeInitConfig writes the initial config values parsed by
+// parseInitConfig to the repo's config, if any
+func (r *Repo) writeInitConfig(config map[[2]string]string) error {
+	defer runtime.KeepAlive(r)
+	if len(config) == 0 {
+		return nil
+	}
+
+	cconfig := C.ostree_repo_copy_config(r.native())
+	defer C.g_key_file_unref(cconfig)
+	for key, value := range config {
+		keyFileSet(cconfig, key[0], key[1], value)
+	}
+	return r.writeConfig(cconfig)
 }
 
 // parseRepoMode converts a mode string to a C.OSTREE_REPO_MODE enum value
@@ -80,6 +80,14 @@ func TestParseRepoMode(t *testing.T) {
 			"bare-user",
 			false,
 		},
+		{
+			"bare-user-only",
+			false,
+		},
+		{
+			"bare-split-xattrs",
+			false,
+		},
 		{
 			"fooMode",
 			true,
@@ -95,3 +103,113 @@ func TestParseRepoMode(t *testing.T) {
 		}
 	}
 }
+
+func TestInitRepoExisting(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+	repoDir := path.Join(baseDir, "repo")
+
+	initOpts := NewInitOptions()
+	initOpts.Mode = "bare-user-only"
+	result, err := InitRepo(repoDir, initOpts)
+	if err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	if !result.Created || result.Mode != "bare-user-only" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// Initializing again reports the existing repo and its actual mode
+	initOpts.Mode = "archive-z2"
+	result, err = InitRepo(repoDir, initOpts)
+	if err != ErrRepoExists {
+		t.Fatalf("expected ErrRepoExists, got %v", err)
+	}
+	if result.Created || result.Mode != "bare-user-only" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// Init keeps succeeding on existing repos
+	inited, err := Init(repoDir, initOpts)
+	if !inited || err != nil {
+		t.Errorf("expected (true, nil), got (%v, %v)", inited, err)
+	}
+}
+
+func TestInitRepoSplitXattrs(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+	repoDir := path.Join(baseDir, "repo")
+
+	initOpts := NewInitOptions()
+	initOpts.Mode = "bare-split-xattrs"
+	result, err := InitRepo(repoDir, initOpts)
+	if err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	if !result.Created || result.Mode != "bare-split-xattrs" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	defer repo.Close()
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if cfg.Mode != "bare-split-xattrs" {
+		t.Errorf("expected mode bare-split-xattrs, got %q", cfg.Mode)
+	}
+}
+
+func TestInitRepoConfig(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+	repoDir := path.Join(baseDir, "repo")
+
+	initOpts := NewInitOptions()
+	initOpts.Mode = "archive-z2"
+	initOpts.CollectionID = "org.example.Os"
+	initOpts.Config = map[string]string{
+		"core.min-free-space-size": "10MB",
+		"remote \"origin\".url":    "https://example.com/repo",
+	}
+	if _, err := InitRepo(repoDir, initOpts); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if cfg.CollectionID != "org.example.Os" || cfg.MinFreeSpaceSize != "10MB" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if url, err := repo.ConfigGet("remote \"origin\"", "url"); err != nil || url != "https://example.com/repo" {
+		t.Errorf("unexpected remote url %q: %v", url, err)
+	}
+
+	initOpts.Config = map[string]string{"nodot": "value"}
+	if _, err := InitRepo(path.Join(baseDir, "repo2"), initOpts); err == nil {
+		t.Error("got unexpected nil error for an invalid config key")
+	}
+}
@@ -17,7 +17,7 @@ import "C"
 
 // LogEntry is a struct for the various pieces of data in a log entry
 type LogEntry struct {
-	Checksum  []byte
+	Checksum  []byte // Checksum of the commit, in hex form
 	Variant   []byte
 	Timestamp time.Time
 	Subject   string
@@ -58,6 +58,7 @@ func Log(repoPath, branch string, options logOptions) ([]LogEntry, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer repo.Close()
 
 	cbranch := C.CString(branch)
 	defer C.free(unsafe.Pointer(cbranch))
@@ -156,6 +157,7 @@ func dumpCommit(variant *C.GVariant, flags ostreeDumpFlags, csum []byte) LogEntr
 	timestamp := time.Unix((int64)(timeHostE), 0)
 
 	return LogEntry{
+		Checksum:  csum,
 		Timestamp: timestamp,
 		Subject:   C.GoString(subject),
 		Body:      C.GoString(body),
@@ -75,3 +75,45 @@ func TestLogSuccess(t *testing.T) {
 		t.Fatal("got no entries")
 	}
 }
+
+func TestLogChecksums(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	commitDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(commitDir)
+
+	var checksums []string
+	for _, content := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+		opts := NewCommitOptions()
+		opts.Subject = content
+		result, err := repo.Commit(commitDir, "main", opts)
+		if err != nil {
+			t.Fatalf("failed to commit: %s", err)
+		}
+		checksums = append(checksums, result.Checksum)
+	}
+
+	// Entries start with the oldest commit
+	entries, err := Log(repo.Path(), "main", NewLogOptions())
+	if err != nil {
+		t.Fatalf("failed to get the log: %s", err)
+	}
+	if len(entries) != len(checksums) {
+		t.Fatalf("expected %d log entries, got %d", len(checksums), len(entries))
+	}
+	for i, entry := range entries {
+		if string(entry.Checksum) != checksums[i] || entry.Subject != []string{"first", "second"}[i] {
+			t.Errorf("unexpected log entry %d: checksum %s subject %q, expected %s", i, entry.Checksum, entry.Subject, checksums[i])
+		}
+	}
+}
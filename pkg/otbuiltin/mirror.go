@@ -0,0 +1,280 @@
+package otbuiltin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// mirrorOptions contains all of the options for mirroring a repo
+//
+// Note: while this is private, fields are public and part of the API.
+type mirrorOptions struct {
+	Refs     []string // Patterns of the refs to mirror, as in path.Match; all local refs if empty
+	Depth    int      // Number of parent commits to mirror (default: -1=infinite)
+	NoDeltas bool     // Don't copy the static deltas leading to mirrored commits
+}
+
+// NewMirrorOptions instantiates and returns a mirrorOptions struct with default values set
+func NewMirrorOptions() mirrorOptions {
+	return mirrorOptions{Depth: -1}
+}
+
+// Mirror copies the local refs of src matching opts.Refs to dst, along with
+// their history up to opts.Depth, the detached metadata (including GPG
+// signatures) of the commits and the static deltas targeting them, then
+// regenerates the summary of dst.  The refs are pulled within a single
+// transaction of dst, so that either all or none of them are updated, and
+// Mirror fails with ErrTransactionActive if dst already has one in progress.
+// It returns the mirrored refs mapped to their checksums.
+//
+// "*" in ref patterns does not match "/", so "*" mirrors only top-level
+// refs; a pattern matching no ref is an error.
+func Mirror(src, dst *Repo, opts mirrorOptions) (map[string]string, error) {
+	if !src.isInitialized() || !dst.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	if dst.inTransaction() {
+		return nil, ErrTransactionActive
+	}
+
+	refs, err := selectMirrorRefs(src, opts.Refs)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+
+	ctx := context.Background()
+	tx, err := dst.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if len(names) > 0 {
+		pullOpts := NewPullOptions()
+		pullOpts.Refs = names
+		pullOpts.Depth = opts.Depth
+		pullOpts.Mirror = true
+		pullOpts.DisableStaticDeltas = true
+		if err := dst.Pull(ctx, "file://"+src.Path(), pullOpts); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if !opts.NoDeltas {
+		if err := copyStaticDeltas(src, dst); err != nil {
+			return nil, err
+		}
+	}
+	if err := dst.RegenerateSummary(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// selectMirrorRefs returns the local refs of repo matching any of patterns,
+// or all local refs if there are no patterns
+func selectMirrorRefs(repo *Repo, patterns []string) (map[string]string, error) {
+	all, err := repo.ListRefs("")
+	if err != nil {
+		return nil, err
+	}
+	for ref := range all {
+		if strings.Contains(ref, ":") {
+			delete(all, ref)
+		}
+	}
+	if len(patterns) == 0 {
+		return all, nil
+	}
+
+	refs := make(map[string]string)
+	for _, pattern := range patterns {
+		matched := false
+		for ref, checksum := range all {
+			ok, err := path.Match(pattern, ref)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ref pattern %q: %s", pattern, err)
+			}
+			if ok {
+				refs[ref] = checksum
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no ref matches %q", pattern)
+		}
+	}
+	return refs, nil
+}
+
+// copyStaticDeltas copies the static deltas of src to dst whose target
+// commit, and source commit if any, are in dst
+func copyStaticDeltas(src, dst *Repo) error {
+	defer runtime.KeepAlive(src)
+	defer runtime.KeepAlive(dst)
+	var cerr *C.GError
+	var cnames *C.GPtrArray
+	if !isOk(C.ostree_repo_list_static_delta_names(src.native(), &cnames, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	defer C.g_ptr_array_unref(cnames)
+
+	pdata := (*[1 << 28]C.gpointer)(unsafe.Pointer(cnames.pdata))[:cnames.len:cnames.len]
+	for _, cname := range pdata {
+		name := C.GoString(C._gptr_to_str(cname))
+		from, to := "", name
+		if i := strings.IndexByte(name, '-'); i >= 0 {
+			from, to = name[:i], name[i+1:]
+		}
+
+		wanted := true
+		for _, checksum := range []string{from, to} {
+			if checksum == "" {
+				continue
+			}
+			have, err := dst.hasObject(ObjectTypeCommit, checksum)
+			if err != nil {
+				return err
+			}
+			wanted = wanted && have
+		}
+		if !wanted {
+			continue
+		}
+
+		deltaPath, err := staticDeltaPath(from, to)
+		if err != nil {
+			return err
+		}
+		if err := copyTree(filepath.Join(src.Path(), deltaPath), filepath.Join(dst.Path(), deltaPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasObject returns whether the repo holds an object
+func (repo *Repo) hasObject(objType ObjectType, checksum string) (bool, error) {
+	defer runtime.KeepAlive(repo)
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+
+	var cerr *C.GError
+	var have C.gboolean
+	if !isOk(C.ostree_repo_has_object(repo.native(), C.OstreeObjectType(objType), cchecksum, &have, nil, &cerr)) {
+		return false, generateError(cerr)
+	}
+	return isOk(have), nil
+}
+
+// staticDeltaPath returns the path of a static delta relative to the repo,
+// the same as libostree: the checksums are encoded in unpadded base64 with
+// "_" instead of "/", and the first two characters form a directory
+func staticDeltaPath(from, to string) (string, error) {
+	toB64, err := checksumToB64(to)
+	if err != nil {
+		return "", err
+	}
+	if from == "" {
+		return path.Join("deltas", toB64[:2], toB64[2:]), nil
+	}
+	fromB64, err := checksumToB64(from)
+	if err != nil {
+		return "", err
+	}
+	return path.Join("deltas", fromB64[:2], fromB64[2:]+"-"+toB64), nil
+}
+
+// checksumToB64 converts a hex checksum to its modified base64 form
+func checksumToB64(checksum string) (string, error) {
+	csum, err := hex.DecodeString(checksum)
+	if err != nil || len(csum) != 32 {
+		return "", fmt.Errorf("invalid checksum %q", checksum)
+	}
+	return strings.Replace(base64.RawStdEncoding.EncodeToString(csum), "/", "_", -1), nil
+}
+
+// copyTree copies the regular files of the directory src to dst, keeping
+// existing files of dst
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dstPath, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if _, err := os.Lstat(dstPath); err == nil {
+			return nil
+		}
+		return copyFile(srcPath, dstPath)
+	})
+}
+
+// copyFile atomically copies the regular file src to dst, so that an
+// interrupted copy never leaves a truncated file at dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,146 @@
+package otbuiltin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMirror(t *testing.T) {
+	src, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer src.Close()
+	first := commitTestFS(t, src, fstest.MapFS{"a": {Data: []byte("a")}}, "app/stable")
+	stable := commitTestFS(t, src, fstest.MapFS{"b": {Data: []byte("b")}}, "app/stable")
+	devel := commitTestFS(t, src, fstest.MapFS{"c": {Data: []byte("c")}}, "app/devel")
+	commitTestFS(t, src, fstest.MapFS{"d": {Data: []byte("d")}}, "os/main")
+
+	dst, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer dst.Close()
+
+	opts := NewMirrorOptions()
+	opts.Refs = []string{"app/*"}
+	mirrored, err := Mirror(src, dst, opts)
+	if err != nil {
+		t.Fatalf("failed to mirror: %s", err)
+	}
+	if len(mirrored) != 2 || mirrored["app/stable"] != stable || mirrored["app/devel"] != devel {
+		t.Errorf("unexpected mirrored refs %v", mirrored)
+	}
+	refs, err := dst.ListRefs("")
+	if err != nil {
+		t.Fatalf("failed to list refs: %s", err)
+	}
+	if len(refs) != 2 || refs["app/stable"] != stable || refs["app/devel"] != devel {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	if have, err := dst.hasObject(ObjectTypeCommit, first); err != nil || !have {
+		t.Errorf("expected the history to be mirrored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst.tempDir, "summary")); err != nil {
+		t.Errorf("expected the summary to be regenerated: %s", err)
+	}
+
+	// Only the tips are mirrored at depth 0
+	shallow, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer shallow.Close()
+	opts = NewMirrorOptions()
+	opts.Depth = 0
+	if _, err := Mirror(src, shallow, opts); err != nil {
+		t.Fatalf("failed to mirror: %s", err)
+	}
+	if refs, err := shallow.ListRefs(""); err != nil || len(refs) != 3 {
+		t.Errorf("expected all refs to be mirrored: %v, %v", refs, err)
+	}
+	if have, err := shallow.hasObject(ObjectTypeCommit, first); err != nil || have {
+		t.Errorf("expected the history not to be mirrored: %v", err)
+	}
+
+	for _, pattern := range []string{"missing/*", "["} {
+		opts = NewMirrorOptions()
+		opts.Refs = []string{pattern}
+		if _, err := Mirror(src, dst, opts); err == nil {
+			t.Errorf("got unexpected nil error mirroring %q", pattern)
+		}
+	}
+
+	// Mirror runs its own transaction and cannot join one of the caller
+	tx, err := dst.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if _, err := Mirror(src, dst, NewMirrorOptions()); err != ErrTransactionActive {
+		t.Errorf("expected ErrTransactionActive, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %s", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	src := filepath.Join(baseDir, "src")
+	if err := ioutil.WriteFile(src, []byte("delta"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	dst := filepath.Join(baseDir, "dst")
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("failed to copy file: %s", err)
+	}
+	if data, err := ioutil.ReadFile(dst); err != nil || string(data) != "delta" {
+		t.Errorf("unexpected copied content %q: %v", data, err)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644: %v, %v", info, err)
+	}
+
+	// No temporary file is left behind, even on failure
+	if err := copyFile(filepath.Join(baseDir, "missing"), dst); err == nil {
+		t.Error("got unexpected nil error copying a missing file")
+	}
+	if err := copyFile(src, filepath.Join(baseDir, "missing", "dst")); err == nil {
+		t.Error("got unexpected nil error copying to a missing directory")
+	}
+	entries, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected only src and dst, got %d entries", len(entries))
+	}
+}
+
+func TestStaticDeltaPath(t *testing.T) {
+	zero := strings.Repeat("00", 32)
+	ones := strings.Repeat("ff", 32)
+
+	p, err := staticDeltaPath("", zero)
+	if err != nil || p != "deltas/AA/"+strings.Repeat("A", 41) {
+		t.Errorf("unexpected delta path %q: %v", p, err)
+	}
+	p, err = staticDeltaPath(zero, ones)
+	if err != nil || p != "deltas/AA/"+strings.Repeat("A", 41)+"-"+strings.Repeat("_", 42)+"w" {
+		t.Errorf("unexpected delta path %q: %v", p, err)
+	}
+	if _, err := staticDeltaPath("", "not-a-checksum"); err == nil {
+		t.Error("got unexpected nil error for an invalid checksum")
+	}
+}
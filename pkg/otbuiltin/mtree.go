@@ -0,0 +1,206 @@
+package otbuiltin
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// MutableTree is an in-memory directory tree used to compose commits,
+// wrapping OstreeMutableTree.  Files are referenced by content checksum and
+// directories need a dirmeta checksum before the tree can be written with
+// Repo.WriteMTree.
+type MutableTree struct {
+	ptr unsafe.Pointer
+}
+
+// NewMutableTree returns a new empty tree
+func NewMutableTree() *MutableTree {
+	return mutableTreeFromNative(C.ostree_mutable_tree_new())
+}
+
+// mutableTreeFromNative takes ownership of a C mutable tree and converts it
+// to a Go struct
+func mutableTreeFromNative(mt *C.OstreeMutableTree) *MutableTree {
+	if mt == nil {
+		return nil
+	}
+	t := &MutableTree{unsafe.Pointer(mt)}
+	runtime.SetFinalizer(t, func(t *MutableTree) {
+		C.g_object_unref(C.gpointer(t.ptr))
+	})
+	return t
+}
+
+// native converts a mutable tree to its C equivalent
+func (t *MutableTree) native() *C.OstreeMutableTree {
+	return (*C.OstreeMutableTree)(t.ptr)
+}
+
+// SetMetadataChecksum sets the checksum of the dirmeta object of the
+// directory
+func (t *MutableTree) SetMetadataChecksum(checksum string) {
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+	C.ostree_mutable_tree_set_metadata_checksum(t.native(), cchecksum)
+	runtime.KeepAlive(t)
+}
+
+// MetadataChecksum returns the checksum of the dirmeta object of the
+// directory, or an empty string if it is not set
+func (t *MutableTree) MetadataChecksum() string {
+	defer runtime.KeepAlive(t)
+	return C.GoString(C.ostree_mutable_tree_get_metadata_checksum(t.native()))
+}
+
+// EnsureDir returns the subdirectory name, creating it if needed.  A new
+// subdirectory has no metadata checksum.
+func (t *MutableTree) EnsureDir(name string) (*MutableTree, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	defer runtime.KeepAlive(t)
+
+	var cerr *C.GError
+	var subdir *C.OstreeMutableTree
+	if !isOk(C.ostree_mutable_tree_ensure_dir(t.native(), cname, &subdir, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	return mutableTreeFromNative(subdir), nil
+}
+
+// ReplaceFile adds the file name with the given content checksum, replacing
+// any existing file of that name
+func (t *MutableTree) ReplaceFile(name, checksum string) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+	defer runtime.KeepAlive(t)
+
+	var cerr *C.GError
+	if !isOk(C.ostree_mutable_tree_replace_file(t.native(), cname, cchecksum, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// Remove removes the file or subdirectory name.  If allowNoent is false, a
+// missing entry is an error.
+func (t *MutableTree) Remove(name string, allowNoent bool) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	defer runtime.KeepAlive(t)
+
+	callowNoent := C.gboolean(C.FALSE)
+	if allowNoent {
+		callowNoent = C.TRUE
+	}
+
+	var cerr *C.GError
+	if !isOk(C.ostree_mutable_tree_remove(t.native(), cname, callowNoent, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// Lookup returns the entry name: the content checksum if it is a file, or
+// the subtree if it is a directory.  A missing entry is an error.
+func (t *MutableTree) Lookup(name string) (string, *MutableTree, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	defer runtime.KeepAlive(t)
+
+	var cerr *C.GError
+	var cchecksum *C.char
+	var subdir *C.OstreeMutableTree
+	if !isOk(C.ostree_mutable_tree_lookup(t.native(), cname, &cchecksum, &subdir, &cerr)) {
+		return "", nil, generateError(cerr)
+	}
+	defer C.g_free(C.gpointer(cchecksum))
+	return C.GoString(cchecksum), mutableTreeFromNative(subdir), nil
+}
+
+// WalkPath returns the existing subtree at the "/"-separated path relative
+// to this tree
+func (t *MutableTree) WalkPath(path string) (*MutableTree, error) {
+	var components []string
+	for _, component := range strings.Split(path, "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+
+	splitPath := C.g_ptr_array_new()
+	defer C.g_ptr_array_unref(splitPath)
+	for _, component := range components {
+		ccomponent := C.CString(component)
+		defer C.free(unsafe.Pointer(ccomponent))
+		C.g_ptr_array_add(splitPath, C.gpointer(ccomponent))
+	}
+	defer runtime.KeepAlive(t)
+
+	var cerr *C.GError
+	var subdir *C.OstreeMutableTree
+	if !isOk(C.ostree_mutable_tree_walk(t.native(), splitPath, 0, &subdir, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	return mutableTreeFromNative(subdir), nil
+}
+
+// FillFromCommit fills the empty tree with the root tree of commit rev.
+// Subtrees are loaded lazily from repo as they are accessed.
+func (t *MutableTree) FillFromCommit(repo *Repo, rev string) error {
+	if !repo.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	crev := C.CString(rev)
+	defer C.free(unsafe.Pointer(crev))
+	defer runtime.KeepAlive(t)
+
+	var cerr *C.GError
+	var root *C.GFile
+	if !isOk(C.ostree_repo_read_commit(repo.native(), crev, &root, nil, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(root))
+
+	repoFile := C._ostree_repo_file(root)
+	if !isOk(C.ostree_repo_file_ensure_resolved(repoFile, &cerr)) {
+		return generateError(cerr)
+	}
+	contents := C.ostree_repo_file_tree_get_contents_checksum(repoFile)
+	metadata := C.ostree_repo_file_tree_get_metadata_checksum(repoFile)
+	if !isOk(C.ostree_mutable_tree_fill_empty_from_dirtree(t.native(), repo.native(), contents, metadata)) {
+		return errors.New("tree is not empty")
+	}
+	return nil
+}
+
+// WriteMTree writes all directories of the tree into the repo and returns
+// the checksum of the root dirtree object; the root dirmeta checksum is
+// mtree.MetadataChecksum().  It must be called within a transaction.
+func (repo *Repo) WriteMTree(mtree *MutableTree) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+	defer runtime.KeepAlive(mtree)
+
+	var cerr *C.GError
+	var root *C.GFile
+	if !isOk(C.ostree_repo_write_mtree(repo.native(), mtree.native(), &root, nil, &cerr)) {
+		return "", generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(root))
+	return C.GoString(C.ostree_repo_file_tree_get_contents_checksum(C._ostree_repo_file(root))), nil
+}
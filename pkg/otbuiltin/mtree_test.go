@@ -0,0 +1,134 @@
+package otbuiltin
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// writeMTreeCommit writes mtree and a commit of it within a transaction,
+// and points branch to the commit
+func writeMTreeCommit(t *testing.T, repo *Repo, mtree *MutableTree, branch string) string {
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	rootTree, err := repo.WriteMTree(mtree)
+	if err != nil {
+		t.Fatalf("failed to write mtree: %s", err)
+	}
+	commit, err := repo.WriteCommitObject(CommitObject{
+		Subject:  branch,
+		RootTree: rootTree,
+		RootMeta: mtree.MetadataChecksum(),
+	})
+	if err != nil {
+		t.Fatalf("failed to write commit: %s", err)
+	}
+	if err := tx.SetRef("", branch, commit); err != nil {
+		t.Fatalf("failed to set ref: %s", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+	return commit
+}
+
+func TestMutableTree(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	// Write the objects shared by both commits
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	motd, err := repo.WriteContent(strings.NewReader("motd"), FileInfo{Mode: syscall.S_IFREG | 0644, Size: 4}, nil)
+	if err != nil {
+		t.Fatalf("failed to write content: %s", err)
+	}
+	issue, err := repo.WriteContent(strings.NewReader("issue"), FileInfo{Mode: syscall.S_IFREG | 0644, Size: 5}, nil)
+	if err != nil {
+		t.Fatalf("failed to write content: %s", err)
+	}
+	dirMeta, err := repo.WriteDirMeta(FileInfo{Mode: 0755}, nil)
+	if err != nil {
+		t.Fatalf("failed to write dirmeta: %s", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	// Compose a base tree from scratch
+	base := NewMutableTree()
+	base.SetMetadataChecksum(dirMeta)
+	etc, err := base.EnsureDir("etc")
+	if err != nil {
+		t.Fatalf("failed to create dir: %s", err)
+	}
+	etc.SetMetadataChecksum(dirMeta)
+	if err := etc.ReplaceFile("motd", motd); err != nil {
+		t.Fatalf("failed to add file: %s", err)
+	}
+	writeMTreeCommit(t, repo, base, "base")
+
+	// Patch the base commit
+	patched := NewMutableTree()
+	if err := patched.FillFromCommit(repo, "base"); err != nil {
+		t.Fatalf("failed to fill tree from commit: %s", err)
+	}
+	if err := patched.FillFromCommit(repo, "base"); err == nil {
+		t.Error("got unexpected nil error filling a non-empty tree")
+	}
+	if patched.MetadataChecksum() != dirMeta {
+		t.Errorf("unexpected metadata checksum %q", patched.MetadataChecksum())
+	}
+	if _, subdir, err := patched.Lookup("etc"); err != nil || subdir == nil {
+		t.Fatalf("expected etc to be a directory: %v", err)
+	}
+	etc, err = patched.WalkPath("/etc/")
+	if err != nil {
+		t.Fatalf("failed to walk to etc: %s", err)
+	}
+	if checksum, subdir, err := etc.Lookup("motd"); err != nil || subdir != nil || checksum != motd {
+		t.Errorf("unexpected lookup of motd: %q, %v, %v", checksum, subdir, err)
+	}
+	if err := etc.ReplaceFile("issue", issue); err != nil {
+		t.Fatalf("failed to add file: %s", err)
+	}
+	if err := etc.Remove("motd", false); err != nil {
+		t.Fatalf("failed to remove file: %s", err)
+	}
+	if err := etc.Remove("motd", false); err == nil {
+		t.Error("got unexpected nil error removing a missing file")
+	}
+	if err := etc.Remove("motd", true); err != nil {
+		t.Errorf("unexpected error removing a missing file: %s", err)
+	}
+	if _, _, err := etc.Lookup("motd"); err == nil {
+		t.Error("got unexpected nil error looking up a removed file")
+	}
+	if _, err := patched.WalkPath("usr/lib"); err == nil {
+		t.Error("got unexpected nil error walking a missing path")
+	}
+	writeMTreeCommit(t, repo, patched, "patched")
+
+	var buf bytes.Buffer
+	if err := repo.Export("patched", &buf, NewExportOptions()); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	headers, contents := readTar(t, &buf)
+	if contents["etc/issue"] != "issue" {
+		t.Errorf("unexpected content %q", contents["etc/issue"])
+	}
+	if _, ok := headers["etc/motd"]; ok {
+		t.Error("expected etc/motd to be removed")
+	}
+}
@@ -0,0 +1,113 @@
+package otbuiltin
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// OpenRepoAt opens the repo at path relative to the directory file
+// descriptor dfd, which may be syscall.AT_FDCWD.  dfd is not closed and
+// must stay open while the repo is in use.
+func OpenRepoAt(dfd int, path string) (*Repo, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	var cerr *C.GError
+	crepo := C.ostree_repo_open_at(C.int(dfd), cpath, nil, &cerr)
+	if crepo == nil {
+		return nil, generateError(cerr)
+	}
+
+	repo := repoFromNative(crepo)
+	runtime.SetFinalizer(repo, (*Repo).Close)
+	return repo, nil
+}
+
+// CreateRepoAt creates a repo at path relative to the directory file
+// descriptor dfd, which may be syscall.AT_FDCWD, and opens it.  Like
+// ostree_repo_create_at, it succeeds on an existing repo, whose mode is
+// left unchanged.
+func CreateRepoAt(dfd int, path string, options initOptions) (*Repo, error) {
+	repoMode, err := parseRepoMode(options.Mode)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseInitConfig(options.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	ctype := C.CString("a{sv}")
+	defer C.free(unsafe.Pointer(ctype))
+	builder := C.g_variant_builder_new(C._g_variant_type(ctype))
+	defer C.g_variant_builder_unref(builder)
+	if options.CollectionID != "" {
+		cformat := C.CString("{sv}")
+		defer C.free(unsafe.Pointer(cformat))
+		ckey := C.CString("collection-id")
+		defer C.free(unsafe.Pointer(ckey))
+		ccollectionID := C.CString(options.CollectionID)
+		defer C.free(unsafe.Pointer(ccollectionID))
+		C._g_variant_builder_add_twoargs(builder, cformat, ckey, C.g_variant_new_string((*C.gchar)(ccollectionID)))
+	}
+	coptions := C.g_variant_ref_sink(C.g_variant_builder_end(builder))
+	defer C.g_variant_unref(coptions)
+
+	var cerr *C.GError
+	crepo := C.ostree_repo_create_at(C.int(dfd), cpath, repoMode, coptions, nil, &cerr)
+	if crepo == nil {
+		return nil, generateError(cerr)
+	}
+
+	repo := repoFromNative(crepo)
+	runtime.SetFinalizer(repo, (*Repo).Close)
+	if err := repo.writeInitConfig(config); err != nil {
+		repo.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// NewTempRepo creates a bare-user repo in a new temporary directory and
+// opens it.  The directory is removed when the repo is closed.
+func NewTempRepo() (*Repo, error) {
+	dir, err := ioutil.TempDir("", "ostree-repo-")
+	if err != nil {
+		return nil, err
+	}
+
+	options := NewInitOptions()
+	options.Mode = "bare-user"
+	repo, err := CreateRepoAt(int(C._at_fdcwd()), dir, options)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	repo.tempDir = dir
+	return repo, nil
+}
+
+// Path returns the path of the repo.  For repos opened with OpenRepoAt,
+// this may be a process-specific path under /proc.
+func (r *Repo) Path() string {
+	if !r.isInitialized() {
+		return ""
+	}
+	defer runtime.KeepAlive(r)
+
+	cpath := C.g_file_get_path(C.ostree_repo_get_path(r.native()))
+	defer C.g_free(C.gpointer(cpath))
+	return C.GoString((*C.char)(cpath))
+}
@@ -0,0 +1,82 @@
+package otbuiltin
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestCreateAndOpenRepoAt(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	dir, err := os.Open(baseDir)
+	if err != nil {
+		t.Fatalf("failed to open %q: %s", baseDir, err)
+	}
+	defer dir.Close()
+	dfd := int(dir.Fd())
+
+	initOpts := NewInitOptions()
+	initOpts.Mode = "archive-z2"
+	initOpts.CollectionID = "org.example.Os"
+	repo, err := CreateRepoAt(dfd, "repo", initOpts)
+	if err != nil {
+		t.Fatalf("failed to create repo: %s", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("failed to close repo: %s", err)
+	}
+
+	repo, err = OpenRepoAt(dfd, "repo")
+	if err != nil {
+		t.Fatalf("failed to open repo: %s", err)
+	}
+	defer repo.Close()
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if cfg.Mode != "archive-z2" || cfg.CollectionID != "org.example.Os" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	if _, err := OpenRepoAt(dfd, "missing"); err == nil {
+		t.Error("got unexpected nil error opening a missing repo")
+	}
+	if _, err := OpenRepoAt(syscall.AT_FDCWD, path.Join(baseDir, "repo")); err != nil {
+		t.Errorf("failed to open repo relative to the working directory: %s", err)
+	}
+}
+
+func TestNewTempRepo(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+
+	if _, err := os.Stat(path.Join(repo.Path(), "config")); err != nil {
+		t.Fatalf("expected repo at %q: %s", repo.Path(), err)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if cfg.Mode != "bare-user" {
+		t.Errorf("expected bare-user mode, got %s", cfg.Mode)
+	}
+
+	tempDir := repo.tempDir
+	if err := repo.Close(); err != nil {
+		t.Fatalf("failed to close repo: %s", err)
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed, got %v", tempDir, err)
+	}
+}
@@ -48,6 +48,7 @@ func Prune(repoPath string, options pruneOptions) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	defer repo.Close()
 
 	var pruneFlags C.OstreeRepoPruneFlags
 	var numObjectsTotal int
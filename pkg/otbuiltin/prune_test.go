@@ -64,7 +64,7 @@ func TestPruneNoPrunePass(t *testing.T) {
 	if err != nil {
 		t.Errorf("%s", err)
 	} else {
-		fmt.Println(ret)
+		fmt.Println(ret.Checksum)
 	}
 
 	_, err = repo.CommitTransaction()
@@ -75,11 +75,11 @@ func TestPruneNoPrunePass(t *testing.T) {
 	// Now let's do some pruning!
 	pruneOpts := NewPruneOptions()
 	pruneOpts.NoPrune = true
-	ret, err = Prune(repoDir, pruneOpts)
+	summary, err := Prune(repoDir, pruneOpts)
 	if err != nil {
 		t.Errorf("%s", err)
 	} else {
-		fmt.Println(ret)
+		fmt.Println(summary)
 	}
 }
 
@@ -0,0 +1,178 @@
+package otbuiltin
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// pullOptions contains all of the options for pulling from a remote
+//
+// Note: while this is private, fields are public and part of the API.
+type pullOptions struct {
+	Refs                []string // Refs or commit checksums to pull; all refs of the remote if empty in mirror mode
+	Depth               int      // Number of parent commits to pull as well (default: 0, -1=infinite)
+	Mirror              bool     // Write refs as local refs rather than remote refs, like `ostree pull --mirror`
+	CommitMetadataOnly  bool     // Only pull commit objects, not their trees
+	DisableStaticDeltas bool     // Pull individual objects even if a static delta is available
+}
+
+// NewPullOptions instantiates and returns a pullOptions struct with default values set
+func NewPullOptions() pullOptions {
+	return pullOptions{}
+}
+
+// AddRemote adds a remote to the repo config.  options holds remote config
+// keys, e.g. "gpg-verify": "false".
+func (repo *Repo) AddRemote(name, url string, options map[string]string) error {
+	if !repo.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	curl := C.CString(url)
+	defer C.free(unsafe.Pointer(curl))
+
+	ctype := C.CString("a{sv}")
+	defer C.free(unsafe.Pointer(ctype))
+	cformat := C.CString("{sv}")
+	defer C.free(unsafe.Pointer(cformat))
+	builder := C.g_variant_builder_new(C._g_variant_type(ctype))
+	defer C.g_variant_builder_unref(builder)
+	for key, value := range options {
+		ckey := C.CString(key)
+		cvalue := C.CString(value)
+		C._g_variant_builder_add_twoargs(builder, cformat, ckey, C.g_variant_new_string((*C.gchar)(cvalue)))
+		C.free(unsafe.Pointer(cvalue))
+		C.free(unsafe.Pointer(ckey))
+	}
+	coptions := C.g_variant_ref_sink(C.g_variant_builder_end(builder))
+	defer C.g_variant_unref(coptions)
+
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_remote_add(repo.native(), cname, curl, coptions, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// DeleteRemote removes a remote from the repo config
+func (repo *Repo) DeleteRemote(name string) error {
+	if !repo.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_remote_delete(repo.native(), cname, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// Pull fetches refs and their objects from remote, the name of a remote
+// of the repo or a file:// URL of a local repo, and updates the refs.  If a
+// transaction is in progress, the pull joins it, and the objects and refs
+// are only committed with it.  Cancelling ctx cancels the pull.
+func (repo *Repo) Pull(ctx context.Context, remote string, opts pullOptions) error {
+	if !repo.isInitialized() {
+		return errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cremote := C.CString(remote)
+	defer C.free(unsafe.Pointer(cremote))
+
+	var flags C.OstreeRepoPullFlags
+	if opts.Mirror {
+		flags |= C.OSTREE_REPO_PULL_FLAGS_MIRROR
+	}
+	if opts.CommitMetadataOnly {
+		flags |= C.OSTREE_REPO_PULL_FLAGS_COMMIT_ONLY
+	}
+
+	dict := C.g_variant_dict_new(nil)
+	defer C.g_variant_dict_unref(dict)
+	variantDictInsert(dict, "flags", C.g_variant_new_int32(C.gint32(flags)))
+	variantDictInsert(dict, "depth", C.g_variant_new_int32(C.gint32(opts.Depth)))
+	if opts.DisableStaticDeltas {
+		variantDictInsert(dict, "disable-static-deltas", C.g_variant_new_boolean(C.TRUE))
+	}
+	if repo.inTransaction() {
+		variantDictInsert(dict, "inherit-transaction", C.g_variant_new_boolean(C.TRUE))
+	}
+	if len(opts.Refs) > 0 {
+		crefs := make([]*C.char, len(opts.Refs))
+		for i, ref := range opts.Refs {
+			crefs[i] = C.CString(ref)
+			defer C.free(unsafe.Pointer(crefs[i]))
+		}
+		variantDictInsert(dict, "refs", C.g_variant_new_strv((**C.gchar)(unsafe.Pointer(&crefs[0])), C.gssize(len(crefs))))
+	}
+	coptions := C.g_variant_ref_sink(C.g_variant_dict_end(dict))
+	defer C.g_variant_unref(coptions)
+
+	cancellable, release := cancellableFromContext(ctx)
+	defer release()
+
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_pull_with_options(repo.native(), cremote, coptions, nil, cancellable, &cerr)) {
+		if ctx.Err() != nil {
+			C.g_error_free(cerr)
+			return ctx.Err()
+		}
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// variantDictInsert inserts a floating value into a GVariantDict
+func variantDictInsert(dict *C.GVariantDict, key string, value *C.GVariant) {
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	C.g_variant_dict_insert_value(dict, (*C.gchar)(ckey), value)
+}
+
+// cancellableFromContext returns a GCancellable cancelled when ctx is done,
+// and a function releasing it which must be called once it is unused
+func cancellableFromContext(ctx context.Context) (*C.GCancellable, func()) {
+	cancellable := C.g_cancellable_new()
+	if ctx.Done() == nil {
+		// ctx is never done, no need to watch it
+		return cancellable, func() {
+			C.g_object_unref(C.gpointer(cancellable))
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			C.g_cancellable_cancel(cancellable)
+		case <-stop:
+		}
+	}()
+	return cancellable, func() {
+		close(stop)
+		<-done
+		C.g_object_unref(C.gpointer(cancellable))
+	}
+}
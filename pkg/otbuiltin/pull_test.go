@@ -0,0 +1,90 @@
+package otbuiltin
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPull(t *testing.T) {
+	src, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer src.Close()
+	first := commitTestFS(t, src, fstest.MapFS{"a": {Data: []byte("a")}}, "main")
+	commitTestFS(t, src, fstest.MapFS{"b": {Data: []byte("b")}}, "main")
+	url := "file://" + src.tempDir
+
+	dst, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer dst.Close()
+
+	// Only the tip is pulled by default
+	opts := NewPullOptions()
+	opts.Refs = []string{"main"}
+	opts.Mirror = true
+	if err := dst.Pull(context.Background(), url, opts); err != nil {
+		t.Fatalf("failed to pull: %s", err)
+	}
+	if _, err := dst.OpenCommitFS("main"); err != nil {
+		t.Errorf("failed to open pulled ref: %s", err)
+	}
+	if _, err := dst.OpenCommitFS(first); err == nil {
+		t.Error("got unexpected nil error opening a commit beyond the pull depth")
+	}
+
+	opts.Depth = -1
+	if err := dst.Pull(context.Background(), url, opts); err != nil {
+		t.Fatalf("failed to pull history: %s", err)
+	}
+	if _, err := dst.OpenCommitFS(first); err != nil {
+		t.Errorf("failed to open parent commit: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dst.Pull(ctx, url, opts); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// A pull within a transaction is discarded with it
+	if err := dst.AddRemote("origin", url, map[string]string{"gpg-verify": "false"}); err != nil {
+		t.Fatalf("failed to add remote: %s", err)
+	}
+	tx, err := dst.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	opts = NewPullOptions()
+	opts.Refs = []string{"main"}
+	if err := dst.Pull(context.Background(), "origin", opts); err != nil {
+		t.Fatalf("failed to pull in transaction: %s", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %s", err)
+	}
+	if _, err := dst.OpenCommitFS("origin:main"); err == nil {
+		t.Error("got unexpected nil error opening a rolled back ref")
+	}
+
+	if err := dst.Pull(context.Background(), "origin", opts); err != nil {
+		t.Fatalf("failed to pull from remote: %s", err)
+	}
+	fsys, err := dst.OpenCommitFS("origin:main")
+	if err != nil {
+		t.Fatalf("failed to open remote ref: %s", err)
+	}
+	if _, err := fs.Stat(fsys, "b"); err != nil {
+		t.Errorf("expected the tip of main to be pulled: %s", err)
+	}
+	if err := dst.DeleteRemote("origin"); err != nil {
+		t.Errorf("failed to delete remote: %s", err)
+	}
+	if err := dst.Pull(context.Background(), "origin", opts); err == nil {
+		t.Error("got unexpected nil error pulling from a deleted remote")
+	}
+}
@@ -0,0 +1,72 @@
+package otbuiltin
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// ListRefs returns the refs of the repo starting with prefix, or all refs
+// if prefix is empty, mapped to their checksums.  Remote refs are named
+// "remote:ref".
+func (repo *Repo) ListRefs(prefix string) (map[string]string, error) {
+	if !repo.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	var cprefix *C.char
+	if prefix != "" {
+		cprefix = C.CString(prefix)
+		defer C.free(unsafe.Pointer(cprefix))
+	}
+
+	var cerr *C.GError
+	var crefs *C.GHashTable
+	if !isOk(C.ostree_repo_list_refs_ext(repo.native(), cprefix, &crefs, C.OSTREE_REPO_LIST_REFS_EXT_NONE, nil, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	defer C.g_hash_table_unref(crefs)
+
+	var hashIter C.GHashTableIter
+	var key, value C.gpointer
+	refs := make(map[string]string, int(C.g_hash_table_size(crefs)))
+	C.g_hash_table_iter_init(&hashIter, crefs)
+	for isOk(C.g_hash_table_iter_next(&hashIter, &key, &value)) {
+		refs[C.GoString(C._gptr_to_str(key))] = C.GoString(C._gptr_to_str(value))
+	}
+	return refs, nil
+}
+
+// ResolveRev returns the checksum of the commit rev, which is a ref, a
+// "remote:ref" or a checksum.  If allowNoent is true, a missing ref
+// resolves to an empty string instead of an error.
+func (repo *Repo) ResolveRev(rev string, allowNoent bool) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	crev := C.CString(rev)
+	defer C.free(unsafe.Pointer(crev))
+
+	callowNoent := C.gboolean(C.FALSE)
+	if allowNoent {
+		callowNoent = C.TRUE
+	}
+
+	var cerr *C.GError
+	var cchecksum *C.char
+	if !isOk(C.ostree_repo_resolve_rev(repo.native(), crev, callowNoent, &cchecksum, &cerr)) {
+		return "", generateError(cerr)
+	}
+	defer C.g_free(C.gpointer(cchecksum))
+	return C.GoString(cchecksum), nil
+}
@@ -0,0 +1,42 @@
+package otbuiltin
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestListRefs(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	stable := commitTestFS(t, repo, fstest.MapFS{"a": {Data: []byte("a")}}, "app/stable")
+	main := commitTestFS(t, repo, fstest.MapFS{"b": {Data: []byte("b")}}, "os/main")
+
+	refs, err := repo.ListRefs("")
+	if err != nil {
+		t.Fatalf("failed to list refs: %s", err)
+	}
+	if len(refs) != 2 || refs["app/stable"] != stable || refs["os/main"] != main {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	refs, err = repo.ListRefs("app")
+	if err != nil {
+		t.Fatalf("failed to list refs: %s", err)
+	}
+	if len(refs) != 1 || refs["app/stable"] != stable {
+		t.Errorf("unexpected refs %v", refs)
+	}
+
+	if rev, err := repo.ResolveRev("os/main", false); err != nil || rev != main {
+		t.Errorf("unexpected resolved rev %q: %v", rev, err)
+	}
+	if rev, err := repo.ResolveRev("missing", true); err != nil || rev != "" {
+		t.Errorf("unexpected resolved rev %q: %v", rev, err)
+	}
+	if _, err := repo.ResolveRev("missing", false); err == nil {
+		t.Error("got unexpected nil error resolving a missing ref")
+	}
+}
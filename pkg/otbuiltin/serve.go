@@ -0,0 +1,143 @@
+package otbuiltin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// servedDirs are the directories of an archive repo fetched by pulls
+var servedDirs = []string{"objects/", "refs/", "deltas/", "delta-indexes/"}
+
+// servedFiles are the files at the top of an archive repo fetched by pulls
+var servedFiles = []string{"config", "summary", "summary.sig"}
+
+// repoHandler serves the files of an archive repo over HTTP
+type repoHandler struct {
+	root string
+}
+
+// NewRepoHandler returns an http.Handler serving the archive repo for
+// pulls, like a static web server pointed at the repo directory.  Only the
+// config, summary, objects, refs and deltas of the repo are served, with
+// Range, ETag and Last-Modified support.  The handler expects request
+// paths relative to the repo; use http.StripPrefix to serve it under a
+// prefix.
+func NewRepoHandler(repo *Repo) (http.Handler, error) {
+	if !repo.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+	if C.ostree_repo_get_mode(repo.native()) != C.OSTREE_REPO_MODE_ARCHIVE {
+		return nil, errors.New("only archive repos can be served")
+	}
+	return &repoHandler{root: repo.Path()}, nil
+}
+
+// ServeHTTP implements http.Handler
+func (h *repoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Cleaning the rooted path drops any ".." escaping the repo
+	name := path.Clean("/" + r.URL.Path)[1:]
+	if !isServedPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Symlinks are not followed, so they cannot point outside the repo
+	info, err := lstatServed(h.root, name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	if !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(h.root, filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	// Refuse the file if it was replaced since it was checked
+	if openInfo, err := f.Stat(); err != nil || !os.SameFile(info, openInfo) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", repoContentType(name))
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// lstatServed returns the file info of the repo file name without following
+// symlinks.  A symlink anywhere in name is reported as not existing.
+func lstatServed(root, name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	p := root
+	for _, elem := range strings.Split(name, "/") {
+		p = filepath.Join(p, elem)
+		var err error
+		info, err = os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, &os.PathError{Op: "lstat", Path: p, Err: os.ErrNotExist}
+		}
+	}
+	return info, nil
+}
+
+// isServedPath returns whether the cleaned path name of a repo file may be
+// served
+func isServedPath(name string) bool {
+	for _, file := range servedFiles {
+		if name == file {
+			return true
+		}
+	}
+	for _, dir := range servedDirs {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// repoContentType returns the content type of a repo file: refs and config
+// are text, everything else is binary
+func repoContentType(name string) string {
+	if name == "config" || strings.HasPrefix(name, "refs/") {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
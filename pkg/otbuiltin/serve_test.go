@@ -0,0 +1,149 @@
+package otbuiltin
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRepoHandler(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	initOpts := NewInitOptions()
+	initOpts.Mode = "archive-z2"
+	src, err := CreateRepoAt(syscall.AT_FDCWD, baseDir, initOpts)
+	if err != nil {
+		t.Fatalf("failed to create repo: %s", err)
+	}
+	defer src.Close()
+	checksum := commitTestFS(t, src, fstest.MapFS{
+		"etc/motd": {Data: []byte("welcome\n"), Mode: 0644},
+	}, "main")
+
+	handler, err := NewRepoHandler(src)
+	if err != nil {
+		t.Fatalf("failed to create handler: %s", err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Pull from the server into a second repo
+	dst, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer dst.Close()
+	if _, err := NewRepoHandler(dst); err == nil {
+		t.Error("got unexpected nil error serving a bare-user repo")
+	}
+	if err := dst.AddRemote("origin", server.URL, map[string]string{"gpg-verify": "false"}); err != nil {
+		t.Fatalf("failed to add remote: %s", err)
+	}
+	pullOpts := NewPullOptions()
+	pullOpts.Refs = []string{"main"}
+	if err := dst.Pull(context.Background(), "origin", pullOpts); err != nil {
+		t.Fatalf("failed to pull: %s", err)
+	}
+	fsys, err := dst.OpenCommitFS("origin:main")
+	if err != nil {
+		t.Fatalf("failed to open pulled commit: %s", err)
+	}
+	if data, err := fs.ReadFile(fsys, "etc/motd"); err != nil || string(data) != "welcome\n" {
+		t.Errorf("unexpected pulled content %q: %v", data, err)
+	}
+
+	// Check the HTTP semantics
+	resp, err := http.Get(server.URL + "/refs/heads/main")
+	if err != nil {
+		t.Fatalf("failed to get ref: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != checksum+"\n" {
+		t.Errorf("unexpected ref response %d %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get("Last-Modified") == "" {
+		t.Errorf("expected ETag and Last-Modified headers, got %v", resp.Header)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/refs/heads/main", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected a not modified response: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/refs/heads/main", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected a partial content response: %v", err)
+	} else {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != checksum[:4] {
+			t.Errorf("unexpected range %q", body)
+		}
+	}
+
+	// Symlinks out of the repo must not be followed
+	outsideDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(outsideDir)
+	if err := ioutil.WriteFile(path.Join(outsideDir, "secret"), []byte("secret\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Symlink(path.Join(outsideDir, "secret"), path.Join(baseDir, "refs/heads/secret")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	if err := os.Symlink(outsideDir, path.Join(baseDir, "refs/remotes/outside")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	for path, status := range map[string]int{
+		"/config":                      http.StatusOK,
+		"/objects/":                    http.StatusNotFound,
+		"/tmp/":                        http.StatusNotFound,
+		"/refs/../config":              http.StatusOK,
+		"/objects/../../etc":           http.StatusNotFound,
+		"/summary":                     http.StatusNotFound,
+		"/refs/heads/secret":           http.StatusNotFound,
+		"/refs/remotes/outside/secret": http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("failed to get %s: %s", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("expected status %d for %s, got %d", status, path, resp.StatusCode)
+		}
+	}
+
+	resp, err = http.Post(server.URL+"/config", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
@@ -0,0 +1,198 @@
+package otbuiltin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// skipPattern is a compiled .gitignore-style pattern
+type skipPattern struct {
+	text    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	matched bool
+}
+
+// skipMatcher decides which paths of a commit are skipped, from
+// .gitignore-style patterns and regular expressions, and records which of
+// them matched
+type skipMatcher struct {
+	patterns []*skipPattern
+	regexps  []*skipPattern
+}
+
+// newSkipMatcher compiles .gitignore-style patterns and regular
+// expressions.  Blank lines and lines starting with "#" are ignored.
+func newSkipMatcher(patterns, regexps []string) (*skipMatcher, error) {
+	m := &skipMatcher{}
+	for _, text := range patterns {
+		p, err := compileSkipPattern(text)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	for _, text := range regexps {
+		re, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip regexp %q: %s", text, err)
+		}
+		m.regexps = append(m.regexps, &skipPattern{text: text, re: re})
+	}
+	return m, nil
+}
+
+// empty reports whether the matcher skips nothing
+func (m *skipMatcher) empty() bool {
+	return m == nil || (len(m.patterns) == 0 && len(m.regexps) == 0)
+}
+
+// skip reports whether the path of the commit, relative to its root with
+// or without a leading "/", is skipped.  As with .gitignore, the last
+// matching pattern wins and a negated pattern re-includes the path.
+// Regular expressions are matched against the path with a leading "/" and
+// skip it regardless of patterns.  The root is never skipped.
+func (m *skipMatcher) skip(name string, isDir bool) bool {
+	name = strings.Trim(name, "/")
+	if m == nil || name == "" {
+		return false
+	}
+
+	skipped := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(name) {
+			p.matched = true
+			skipped = !p.negate
+		}
+	}
+	for _, p := range m.regexps {
+		if p.re.MatchString("/" + name) {
+			p.matched = true
+			skipped = true
+		}
+	}
+	return skipped
+}
+
+// unmatched returns the patterns and regular expressions which matched no
+// path
+func (m *skipMatcher) unmatched() []string {
+	if m == nil {
+		return nil
+	}
+
+	var texts []string
+	for _, p := range append(m.patterns, m.regexps...) {
+		if !p.matched {
+			texts = append(texts, p.text)
+		}
+	}
+	return texts
+}
+
+// compileSkipPattern compiles a .gitignore-style pattern, returning nil for
+// blank lines and comments:
+//
+//   - "!" negates the pattern and "\" escapes the next character
+//   - a trailing "/" only matches directories
+//   - a pattern containing a "/" other than trailing is relative to the
+//     root, otherwise it matches at any depth
+//   - "*" and "?" match within a path element, "[...]" a character class
+//   - "**/" matches any leading directories, "/**" anything inside a
+//     directory and "/**/" zero or more directories
+func compileSkipPattern(text string) (*skipPattern, error) {
+	line := strings.TrimRight(text, " \t")
+	if strings.HasSuffix(line, "\\") && len(line) < len(text) {
+		// The escaped space is significant
+		line += " "
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	p := &skipPattern{text: text}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil, fmt.Errorf("invalid skip pattern %q", text)
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '*' && strings.HasPrefix(line[i:], "**") && (i == 0 || line[i-1] == '/') && (i+2 == len(line) || line[i+2] == '/'):
+			switch {
+			case i+2 == len(line):
+				// Trailing "**" matches everything inside the directory
+				expr.WriteString(".*")
+			default:
+				// "**/" matches zero or more directories
+				expr.WriteString("(?:.*/)?")
+				i++
+			}
+			i++
+		case c == '*':
+			for i+1 < len(line) && line[i+1] == '*' {
+				i++
+			}
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid skip pattern %q: unterminated character class", text)
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			expr.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid skip pattern %q: %s", text, err)
+	}
+	p.re = re
+	return p, nil
+}
+
+// readSkipListFile reads the .gitignore-style patterns of a skip list file,
+// one per line
+func readSkipListFile(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(contents), "\n"), nil
+}
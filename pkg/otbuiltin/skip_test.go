@@ -0,0 +1,62 @@
+package otbuiltin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSkipMatcher(t *testing.T) {
+	m, err := newSkipMatcher([]string{
+		"# comment",
+		"",
+		"*.pyc",
+		"/var/cache/**",
+		"!/var/cache/keep",
+		"tmp/",
+		"/usr/**/doc",
+		`\#literal`,
+		"unused-[ab]",
+	}, []string{`^/etc/.*\.bak$`})
+	if err != nil {
+		t.Fatalf("failed to compile skip patterns: %s", err)
+	}
+
+	for _, c := range []struct {
+		name  string
+		isDir bool
+		skip  bool
+	}{
+		{"/", true, false},
+		{"/foo.pyc", false, true},
+		{"/usr/lib/python/foo.pyc", false, true},
+		{"/usr/lib/python/foo.py", false, false},
+		{"/var/cache", true, false},
+		{"/var/cache/dnf", true, true},
+		{"/var/cache/keep", false, false},
+		{"/srv/tmp", true, true},
+		{"/srv/tmp", false, false},
+		{"/usr/doc", true, true},
+		{"/usr/share/doc", true, true},
+		{"/usr/share/doc-base", true, false},
+		{"/#literal", false, true},
+		{"/etc/passwd.bak", false, true},
+		{"/etc/passwd", false, false},
+	} {
+		if skip := m.skip(c.name, c.isDir); skip != c.skip {
+			t.Errorf("skip(%q, %v) = %v, expected %v", c.name, c.isDir, skip, c.skip)
+		}
+	}
+
+	if unmatched := m.unmatched(); !reflect.DeepEqual(unmatched, []string{"unused-[ab]"}) {
+		t.Errorf("unexpected unmatched patterns %q", unmatched)
+	}
+
+	for _, pattern := range []string{"[abc", "/", "!"} {
+		if _, err := newSkipMatcher([]string{pattern}, nil); err == nil {
+			t.Errorf("expected pattern %q to be invalid", pattern)
+		}
+	}
+	if _, err := newSkipMatcher(nil, []string{"("}); err == nil {
+		t.Error("expected regexp \"(\" to be invalid")
+	}
+}
@@ -0,0 +1,325 @@
+package otbuiltin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StatOverride changes the permissions and ownership of the files of a
+// commit matching Path.  Path starts with "/" and is a .gitignore-style
+// pattern, so it may contain "*", "?", "[...]" and "**"; a trailing "/"
+// only matches directories.
+//
+// Mode, UID and GID are -1 to keep the value of the file.  RemoveMode then
+// AddMode are applied after Mode.  Symbolic links keep their mode.  As with
+// chown(2), changing the owner of a regular file clears its setuid and
+// setgid bits unless the override sets them.  The setuid bit only applies
+// to regular files and the setgid bit to regular files and directories.
+type StatOverride struct {
+	Path       string
+	Mode       int
+	AddMode    uint32
+	RemoveMode uint32
+	UID        int
+	GID        int
+}
+
+// NewStatOverride returns a StatOverride of path which changes nothing
+func NewStatOverride(path string) StatOverride {
+	return StatOverride{Path: path, Mode: -1, UID: -1, GID: -1}
+}
+
+// Setuid and setgid bits of a mode
+const (
+	modeSetuid = 04000
+	modeSetgid = 02000
+)
+
+// ParseStatOverrides parses stat overrides, one per line:
+//
+//	# comment
+//	0755 /usr/bin/tool
+//	+04000 uid=0 gid=0 /usr/bin/su
+//	-0022 /etc/**
+//	uid=1000 gid=1000 /home/user/
+//
+// A line holds one or more changes followed by the path pattern, which
+// starts with "/" and extends to the end of the line.  Changes are an
+// octal mode to set, "+MODE" and "-MODE" to add and remove mode bits, and
+// "uid=ID" and "gid=ID".  The mode bits to add must start with "0".
+//
+// Lines of the legacy ostree format, "+MODE /path" where MODE is decimal
+// with no leading "0", are also accepted: "+2048 /usr/bin/foo" adds the
+// setuid bit, 04000, to the file /usr/bin/foo, whose path is not a
+// pattern.  name is used in error messages.
+func ParseStatOverrides(r io.Reader, name string) ([]StatOverride, error) {
+	var overrides []StatOverride
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		override, err := parseStatOverride(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, lineno, err)
+		}
+		overrides = append(overrides, override)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// readStatOverrideFile parses the stat overrides of a file
+func readStatOverrideFile(path string) ([]StatOverride, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseStatOverrides(f, path)
+}
+
+// parseStatOverride parses a line of stat overrides
+func parseStatOverride(line string) (StatOverride, error) {
+	// The path is the first field starting with "/"
+	slash := -1
+	for i := range line {
+		if line[i] == '/' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			slash = i
+			break
+		}
+	}
+	if slash < 0 {
+		return StatOverride{}, fmt.Errorf("missing path starting with \"/\" in %q", line)
+	}
+	override := NewStatOverride(line[slash:])
+	if slash == 0 {
+		return override, fmt.Errorf("missing changes before path in %q", line)
+	}
+
+	fields := strings.Fields(line[:slash])
+	if len(fields) == 1 && isLegacyStatOverrideMode(fields[0]) {
+		mode, err := strconv.ParseUint(fields[0][1:], 10, 32)
+		if err != nil || mode&^07777 != 0 {
+			return override, fmt.Errorf("invalid legacy change %q: mode out of range 0-4095", fields[0])
+		}
+		override.Path = escapeSkipPattern(override.Path)
+		override.AddMode = uint32(mode)
+		return override, nil
+	}
+
+	for _, field := range fields {
+		var err error
+		switch {
+		case strings.HasPrefix(field, "uid="):
+			override.UID, err = parseStatOverrideID(field[len("uid="):])
+		case strings.HasPrefix(field, "gid="):
+			override.GID, err = parseStatOverrideID(field[len("gid="):])
+		case isLegacyStatOverrideMode(field):
+			err = fmt.Errorf("octal mode must start with \"0\", decimal legacy modes must be alone on their line")
+		case strings.HasPrefix(field, "+"):
+			override.AddMode, err = parseStatOverrideMode(field[1:])
+		case strings.HasPrefix(field, "-"):
+			override.RemoveMode, err = parseStatOverrideMode(field[1:])
+		default:
+			var mode uint32
+			mode, err = parseStatOverrideMode(field)
+			override.Mode = int(mode)
+		}
+		if err != nil {
+			return override, fmt.Errorf("invalid change %q: %s", field, err)
+		}
+	}
+	return override, nil
+}
+
+// isLegacyStatOverrideMode reports whether a change is a decimal mode to add
+// of the legacy ostree format
+func isLegacyStatOverrideMode(field string) bool {
+	if len(field) < 2 || field[0] != '+' || field[1] == '0' {
+		return false
+	}
+	for _, c := range field[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeSkipPattern escapes the pattern characters of a literal path
+func escapeSkipPattern(path string) string {
+	var escaped strings.Builder
+	for _, c := range path {
+		if strings.ContainsRune(`*?[\`, c) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
+
+// parseStatOverrideMode parses octal permission bits
+func parseStatOverrideMode(s string) (uint32, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("mode must be octal")
+	}
+	if mode&^07777 != 0 {
+		return 0, fmt.Errorf("mode out of range 0-07777")
+	}
+	return uint32(mode), nil
+}
+
+// parseStatOverrideID parses a numeric user or group ID
+func parseStatOverrideID(s string) (int, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("ID must be a non-negative integer")
+	}
+	return int(id), nil
+}
+
+// statOverrides is a compiled list of StatOverrides, recording which of
+// them matched
+type statOverrides struct {
+	overrides []StatOverride
+	patterns  []*skipPattern
+}
+
+// newStatOverrides compiles the patterns of overrides
+func newStatOverrides(overrides []StatOverride) (*statOverrides, error) {
+	s := &statOverrides{overrides: overrides}
+	for _, override := range overrides {
+		if !strings.HasPrefix(override.Path, "/") {
+			return nil, fmt.Errorf("stat override path %q must start with \"/\"", override.Path)
+		}
+		if override.Mode > 07777 || override.AddMode&^07777 != 0 || override.RemoveMode&^07777 != 0 {
+			return nil, fmt.Errorf("stat override mode of %q out of range 0-07777", override.Path)
+		}
+		p, err := compileSkipPattern(override.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	return s, nil
+}
+
+// empty reports whether there are no overrides
+func (s *statOverrides) empty() bool {
+	return s == nil || len(s.overrides) == 0
+}
+
+// apply applies the overrides matching the path of the commit, relative to
+// its root, to a st_mode, uid and gid.  It returns whether any matched.
+func (s *statOverrides) apply(name string, mode, uid, gid *uint32) bool {
+	name = strings.Trim(name, "/")
+	if s == nil || name == "" {
+		return false
+	}
+
+	fileType := *mode & modeTypeMask
+	if fileType == modeTypeSymlink {
+		// Symbolic links always have mode 0777
+		return s.applyOwner(name, fileType, uid, gid)
+	}
+
+	perms := *mode &^ modeTypeMask
+	original := perms
+	var explicit uint32
+	chowned := false
+	applied := false
+	for i, p := range s.patterns {
+		if !s.matches(i, name, fileType) {
+			continue
+		}
+		p.matched = true
+		applied = true
+
+		override := s.overrides[i]
+		if override.UID >= 0 {
+			*uid = uint32(override.UID)
+			chowned = true
+		}
+		if override.GID >= 0 {
+			*gid = uint32(override.GID)
+			chowned = true
+		}
+		if override.Mode >= 0 {
+			perms = uint32(override.Mode)
+			explicit |= uint32(override.Mode)
+		}
+		perms &^= override.RemoveMode
+		perms |= override.AddMode
+		explicit |= override.AddMode
+	}
+
+	// Drop the setuid and setgid bits the file type doesn't support, and
+	// those a change of owner clears
+	introduced := perms &^ original
+	switch fileType {
+	case modeTypeRegular:
+		if chowned {
+			perms &^= (modeSetuid | modeSetgid) &^ explicit
+		}
+	case modeTypeDir:
+		perms &^= introduced & modeSetuid
+	default:
+		perms &^= introduced & (modeSetuid | modeSetgid)
+	}
+	*mode = fileType | perms
+	return applied
+}
+
+// applyOwner applies the ownership changes of the overrides matching a
+// path whose mode can't change
+func (s *statOverrides) applyOwner(name string, fileType uint32, uid, gid *uint32) bool {
+	applied := false
+	for i, p := range s.patterns {
+		if !s.matches(i, name, fileType) {
+			continue
+		}
+		p.matched = true
+		applied = true
+		if s.overrides[i].UID >= 0 {
+			*uid = uint32(s.overrides[i].UID)
+		}
+		if s.overrides[i].GID >= 0 {
+			*gid = uint32(s.overrides[i].GID)
+		}
+	}
+	return applied
+}
+
+// matches reports whether the override i matches the path
+func (s *statOverrides) matches(i int, name string, fileType uint32) bool {
+	p := s.patterns[i]
+	if p.dirOnly && fileType != modeTypeDir {
+		return false
+	}
+	return p.re.MatchString(name)
+}
+
+// unmatched returns the paths of the overrides which matched no file
+func (s *statOverrides) unmatched() []string {
+	if s == nil {
+		return nil
+	}
+
+	var paths []string
+	for i, p := range s.patterns {
+		if !p.matched {
+			paths = append(paths, s.overrides[i].Path)
+		}
+	}
+	return paths
+}
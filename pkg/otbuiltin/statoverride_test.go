@@ -0,0 +1,170 @@
+package otbuiltin
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStatOverrides(t *testing.T) {
+	overrides, err := ParseStatOverrides(strings.NewReader(`# comment
+
+0755 /usr/bin/tool
++04000 uid=0 gid=0 /usr/bin/su
+	-0022 /etc/**
+uid=1000 gid=1000 /home/user name/
+`), "overrides")
+	if err != nil {
+		t.Fatalf("failed to parse stat overrides: %s", err)
+	}
+
+	tool := NewStatOverride("/usr/bin/tool")
+	tool.Mode = 0755
+	su := NewStatOverride("/usr/bin/su")
+	su.AddMode = 04000
+	su.UID = 0
+	su.GID = 0
+	etc := NewStatOverride("/etc/**")
+	etc.RemoveMode = 0022
+	home := NewStatOverride("/home/user name/")
+	home.UID = 1000
+	home.GID = 1000
+	if expected := []StatOverride{tool, su, etc, home}; !reflect.DeepEqual(overrides, expected) {
+		t.Errorf("unexpected stat overrides %+v, expected %+v", overrides, expected)
+	}
+
+	for text, msg := range map[string]string{
+		"0755 /ok\n2048.0 /usr/bin/foo": "overrides:2: invalid change \"2048.0\"",
+		"# comment\n+0789 /usr/bin/foo": "overrides:2: invalid change \"+0789\": mode must be octal",
+		"010000 /usr/bin/foo":           "overrides:1: invalid change \"010000\": mode out of range",
+		"uid=-1 /usr/bin/foo":           "overrides:1: invalid change \"uid=-1\"",
+		"0755 usr/bin/foo":              "overrides:1: missing path",
+		"/usr/bin/foo":                  "overrides:1: missing changes",
+		"\n\n0755/usr/bin/foo":          "overrides:3: missing path",
+		"gid=wheel /usr/bin/foo":        "overrides:1: invalid change \"gid=wheel\"",
+		"+755 uid=0 /usr/bin/foo":       "overrides:1: invalid change \"+755\": octal mode must start with \"0\"",
+		"+8192 /usr/bin/foo":            "overrides:1: invalid legacy change \"+8192\"",
+	} {
+		if _, err := ParseStatOverrides(strings.NewReader(text), "overrides"); err == nil || !strings.HasPrefix(err.Error(), msg) {
+			t.Errorf("expected an error starting with %q parsing %q, got %v", msg, text, err)
+		}
+	}
+}
+
+func TestReadLegacyStatOverrideFile(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	// The legacy ostree and rpm-ostree format has decimal modes to add
+	overridesFile := path.Join(baseDir, "statoverride")
+	if err := ioutil.WriteFile(overridesFile, []byte("+2048 /usr/bin/foo\n+512 /tmp\n+1024 /usr/lib/a*b\n"), 0644); err != nil {
+		t.Fatalf("failed to write stat overrides: %s", err)
+	}
+	overrides, err := readStatOverrideFile(overridesFile)
+	if err != nil {
+		t.Fatalf("failed to read stat overrides: %s", err)
+	}
+
+	foo := NewStatOverride("/usr/bin/foo")
+	foo.AddMode = modeSetuid
+	tmp := NewStatOverride("/tmp")
+	tmp.AddMode = 01000
+	literal := NewStatOverride(`/usr/lib/a\*b`)
+	literal.AddMode = modeSetgid
+	if expected := []StatOverride{foo, tmp, literal}; !reflect.DeepEqual(overrides, expected) {
+		t.Fatalf("unexpected stat overrides %+v, expected %+v", overrides, expected)
+	}
+
+	s, err := newStatOverrides(overrides)
+	if err != nil {
+		t.Fatalf("failed to compile stat overrides: %s", err)
+	}
+	for _, c := range []struct {
+		name     string
+		mode     uint32
+		expected uint32
+	}{
+		{"/usr/bin/foo", modeTypeRegular | 0755, modeTypeRegular | 04755},
+		{"/tmp", modeTypeDir | 0777, modeTypeDir | 01777},
+		// Legacy paths are not patterns
+		{"/usr/lib/a*b", modeTypeRegular | 0644, modeTypeRegular | 02644},
+		{"/usr/lib/axb", modeTypeRegular | 0644, modeTypeRegular | 0644},
+	} {
+		mode, uid, gid := c.mode, uint32(0), uint32(0)
+		s.apply(c.name, &mode, &uid, &gid)
+		if mode != c.expected {
+			t.Errorf("%s: got mode %o, expected %o", c.name, mode, c.expected)
+		}
+	}
+}
+
+func TestStatOverridesApply(t *testing.T) {
+	tool := NewStatOverride("/usr/bin/tool")
+	tool.Mode = 0700
+	etc := NewStatOverride("/etc/**")
+	etc.RemoveMode = 0022
+	su := NewStatOverride("/usr/bin/su")
+	su.AddMode = 04000
+	su.UID = 0
+	chown := NewStatOverride("/usr/bin/*")
+	chown.UID = 1000
+	dirs := NewStatOverride("/srv/**/")
+	dirs.AddMode = 06000
+	link := NewStatOverride("/usr/lib/link")
+	link.Mode = 0600
+	link.GID = 10
+	unused := NewStatOverride("/nonexistent")
+	unused.Mode = 0644
+
+	s, err := newStatOverrides([]StatOverride{tool, etc, chown, su, dirs, link, unused})
+	if err != nil {
+		t.Fatalf("failed to compile stat overrides: %s", err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		mode     uint32
+		expected uint32
+		uid      uint32
+		gid      uint32
+	}{
+		// An absolute mode, then the owner change clears nothing
+		{"/usr/bin/tool", modeTypeRegular | 0755, modeTypeRegular | 0700, 1000, 0},
+		{"/etc/passwd", modeTypeRegular | 0666, modeTypeRegular | 0644, 0, 0},
+		// Changing the owner clears setuid and setgid, unless set again
+		{"/usr/bin/sudo", modeTypeRegular | 06755, modeTypeRegular | 0755, 1000, 0},
+		{"/usr/bin/su", modeTypeRegular | 02755, modeTypeRegular | 04755, 0, 0},
+		// Directories only take the setgid bit
+		{"/srv/data", modeTypeDir | 0755, modeTypeDir | 02755, 0, 0},
+		{"/srv/data/file", modeTypeRegular | 0644, modeTypeRegular | 0644, 0, 0},
+		// Symbolic links keep their mode
+		{"/usr/lib/link", modeTypeSymlink | 0777, modeTypeSymlink | 0777, 0, 10},
+		{"/usr/lib/other", modeTypeRegular | 04755, modeTypeRegular | 04755, 0, 0},
+	} {
+		mode, uid, gid := c.mode, uint32(0), uint32(0)
+		s.apply(c.name, &mode, &uid, &gid)
+		if mode != c.expected || uid != c.uid || gid != c.gid {
+			t.Errorf("%s: got mode %o uid %d gid %d, expected mode %o uid %d gid %d", c.name, mode, uid, gid, c.expected, c.uid, c.gid)
+		}
+	}
+
+	if unmatched := s.unmatched(); !reflect.DeepEqual(unmatched, []string{"/nonexistent"}) {
+		t.Errorf("unexpected unmatched stat overrides %q", unmatched)
+	}
+
+	if _, err := newStatOverrides([]StatOverride{NewStatOverride("usr/bin/foo")}); err == nil {
+		t.Error("expected a relative stat override path to be invalid")
+	}
+	bad := NewStatOverride("/usr/bin/foo")
+	bad.AddMode = 0170000
+	if _, err := newStatOverrides([]StatOverride{bad}); err == nil {
+		t.Error("expected a stat override adding file type bits to be invalid")
+	}
+}
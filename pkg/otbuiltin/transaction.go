@@ -0,0 +1,176 @@
+package otbuiltin
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+var (
+	// ErrTransactionActive is returned by BeginTransaction when the repo
+	// already has a transaction in progress
+	ErrTransactionActive = errors.New("a transaction is already in progress")
+	// ErrTransactionDone is returned when using a transaction which has
+	// already been committed or rolled back
+	ErrTransactionDone = errors.New("transaction has already been committed or rolled back")
+)
+
+// Transaction is a repo transaction started with Repo.BeginTransaction.
+// All methods are safe for concurrent use; once Commit or Rollback has
+// returned, the transaction is finished and further calls fail with
+// ErrTransactionDone, except Rollback which is a no-op.
+type Transaction struct {
+	repo *Repo
+	// resumed is true if the transaction resumed a previously interrupted one
+	resumed bool
+
+	mu          sync.Mutex
+	done        bool
+	cancellable *C.GCancellable
+	release     func()
+}
+
+// BeginTransaction starts a transaction on the repo.  Only one transaction
+// may be in progress per repo.  Cancelling ctx cancels an in-flight Commit;
+// the transaction must still be rolled back.
+//
+//	tx, err := repo.BeginTransaction(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer tx.Rollback()
+//	...
+//	_, err = tx.Commit()
+func (repo *Repo) BeginTransaction(ctx context.Context) (*Transaction, error) {
+	if !repo.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.txn != nil || repo.prepared {
+		return nil, ErrTransactionActive
+	}
+
+	resumed, err := repo.prepareTransaction()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Transaction{repo: repo, resumed: resumed}
+	tx.cancellable, tx.release = cancellableFromContext(ctx)
+	repo.txn = tx
+	return tx, nil
+}
+
+// inTransaction reports whether a transaction started by BeginTransaction or
+// PrepareTransaction is in progress
+func (repo *Repo) inTransaction() bool {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.txn != nil || repo.prepared
+}
+
+// Resumed reports whether the transaction resumed an interrupted one
+func (tx *Transaction) Resumed() bool {
+	return tx.resumed
+}
+
+// SetRef sets ref, of remote if not empty, to checksum when the transaction
+// is committed.  An empty checksum deletes the ref.
+func (tx *Transaction) SetRef(remote, ref, checksum string) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTransactionDone
+	}
+
+	tx.repo.TransactionSetRef(remote, ref, checksum)
+	return nil
+}
+
+// SetCollectionRef sets the ref (collectionID, ref) to checksum when the
+// transaction is committed.  An empty checksum deletes the ref.
+func (tx *Transaction) SetCollectionRef(collectionID, ref, checksum string) error {
+	defer runtime.KeepAlive(tx.repo)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTransactionDone
+	}
+
+	var cchecksum *C.char
+	if checksum != "" {
+		cchecksum = C.CString(checksum)
+		defer C.free(unsafe.Pointer(cchecksum))
+	}
+
+	collectionRef := CollectionRef{collectionID, ref}.native()
+	defer C.ostree_collection_ref_free(collectionRef)
+	C.ostree_repo_transaction_set_collection_ref(tx.repo.native(), collectionRef, cchecksum)
+	return nil
+}
+
+// Commit commits the transaction, writing all refs set on it, and returns
+// the statistics of the transaction.  If it fails, the transaction is
+// still in progress and must be rolled back.
+func (tx *Transaction) Commit() (*TransactionStats, error) {
+	defer runtime.KeepAlive(tx.repo)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil, ErrTransactionDone
+	}
+
+	var cerr *C.GError
+	var stats C.OstreeRepoTransactionStats
+	if !isOk(C.ostree_repo_commit_transaction(tx.repo.native(), &stats, tx.cancellable, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	tx.finish()
+	return transactionStatsFromNative(&stats), nil
+}
+
+// Rollback aborts the transaction, discarding all refs set on it.  It is a
+// no-op if the transaction has already been committed or rolled back, so it
+// is safe to defer right after BeginTransaction.
+func (tx *Transaction) Rollback() error {
+	defer runtime.KeepAlive(tx.repo)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil
+	}
+
+	// Not cancellable, so that rolling back after ctx is done still works
+	var cerr *C.GError
+	if !isOk(C.ostree_repo_abort_transaction(tx.repo.native(), nil, &cerr)) {
+		return generateError(cerr)
+	}
+	tx.finish()
+	return nil
+}
+
+// finish marks the transaction as done and releases it from the repo.
+// Must be called with tx.mu held.
+func (tx *Transaction) finish() {
+	tx.done = true
+	tx.release()
+	tx.cancellable = nil
+
+	tx.repo.mu.Lock()
+	tx.repo.txn = nil
+	tx.repo.mu.Unlock()
+}
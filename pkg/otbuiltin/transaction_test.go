@@ -0,0 +1,145 @@
+package otbuiltin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	repoDir := path.Join(baseDir, "repo")
+	if _, err := InitRepo(repoDir, NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+	commitDir := path.Join(baseDir, "commit1")
+	if err := os.Mkdir(commitDir, 0755); err != nil {
+		t.Fatalf("failed to make commit dir: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	repo, err := OpenRepo(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repo at %q: %s", repoDir, err)
+	}
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	if _, err := repo.BeginTransaction(context.Background()); err != ErrTransactionActive {
+		t.Errorf("expected ErrTransactionActive, got %v", err)
+	}
+
+	result, err := repo.Commit(commitDir, "test-branch", NewCommitOptions())
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	checksum := result.Checksum
+	if err := tx.SetRef("", "copy-branch", checksum); err != nil {
+		t.Fatalf("failed to set ref: %s", err)
+	}
+	stats, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+	// One file, plus the commit, dirtree and dirmeta objects
+	if stats.ContentObjectsWritten != 1 || stats.ContentObjectsTotal != 1 || stats.MetadataObjectsWritten < 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	ref, err := ioutil.ReadFile(path.Join(repoDir, "refs", "heads", "copy-branch"))
+	if err != nil {
+		t.Fatalf("failed to read ref: %s", err)
+	}
+	if strings.TrimSpace(string(ref)) != checksum {
+		t.Errorf("expected ref to point to %s, got %s", checksum, ref)
+	}
+
+	if _, err := tx.Commit(); err != ErrTransactionDone {
+		t.Errorf("expected ErrTransactionDone, got %v", err)
+	}
+	if err := tx.SetRef("", "test-branch", ""); err != ErrTransactionDone {
+		t.Errorf("expected ErrTransactionDone, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("unexpected error rolling back a committed transaction: %s", err)
+	}
+
+	// A rolled back transaction discards its refs and frees the repo
+	tx, err = repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if err := tx.SetRef("", "other-branch", checksum); err != nil {
+		t.Fatalf("failed to set ref: %s", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %s", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("unexpected error rolling back twice: %s", err)
+	}
+	if _, err := os.Stat(path.Join(repoDir, "refs", "heads", "other-branch")); !os.IsNotExist(err) {
+		t.Errorf("expected rolled back ref to be missing, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.BeginTransaction(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestTransactionMixedEntryPoints(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	// PrepareTransaction then BeginTransaction
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	if _, err := repo.BeginTransaction(context.Background()); err != ErrTransactionActive {
+		t.Errorf("expected ErrTransactionActive, got %v", err)
+	}
+	if _, err := repo.PrepareTransaction(); err != ErrTransactionActive {
+		t.Errorf("expected ErrTransactionActive, got %v", err)
+	}
+	if err := repo.AbortTransaction(); err != nil {
+		t.Fatalf("failed to abort transaction: %s", err)
+	}
+
+	// BeginTransaction then PrepareTransaction
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if _, err := repo.PrepareTransaction(); err != ErrTransactionActive {
+		t.Errorf("expected ErrTransactionActive, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back transaction: %s", err)
+	}
+
+	// Both work again once the transaction is over
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+}
@@ -0,0 +1,479 @@
+package otbuiltin
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// maxSymlinks is the maximum number of symbolic links followed while
+// resolving a path, like the kernel's limit
+const maxSymlinks = 40
+
+// CommitFileSys holds the ostree metadata of a file of a commit, returned by
+// the Sys method of the fs.FileInfo values of OpenCommitFS
+type CommitFileSys struct {
+	// Checksum is the content checksum of a file, or the dirtree checksum of a directory
+	Checksum string
+	// MetaChecksum is the dirmeta checksum of a directory, empty for files
+	MetaChecksum string
+	UID          uint32
+	GID          uint32
+	Xattrs       map[string][]byte
+}
+
+// treeFS is the read-only file system of a commit
+type treeFS struct {
+	// mu serializes the lazy loading of dirtree objects by the GFiles
+	mu      sync.Mutex
+	root    unsafe.Pointer
+	modTime time.Time
+}
+
+// OpenCommitFS returns the tree of commit `rev` as a read-only file system,
+// implementing fs.ReadDirFS, fs.StatFS and fs.ReadFileFS as well as
+// ReadLink and Lstat.  Symbolic links are followed within the tree,
+// absolute targets being relative to its root.  All files get the
+// timestamp of the commit and their fs.FileInfo.Sys is a *CommitFileSys.
+// The file system stays valid after the repo is closed.
+func (repo *Repo) OpenCommitFS(rev string) (fs.FS, error) {
+	if !repo.isInitialized() {
+		return nil, errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	crev := C.CString(rev)
+	defer C.free(unsafe.Pointer(crev))
+
+	var cerr *C.GError
+	var root *C.GFile
+	var checksum *C.char
+	if !isOk(C.ostree_repo_read_commit(repo.native(), crev, &root, &checksum, nil, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	defer C.g_free(C.gpointer(checksum))
+
+	var commit *C.GVariant
+	if !isOk(C.ostree_repo_load_variant(repo.native(), C.OSTREE_OBJECT_TYPE_COMMIT, checksum, &commit, &cerr)) {
+		C.g_object_unref(C.gpointer(root))
+		return nil, generateError(cerr)
+	}
+	timestamp := C.ostree_commit_get_timestamp(commit)
+	C.g_variant_unref(commit)
+
+	fsys := &treeFS{
+		root:    unsafe.Pointer(root),
+		modTime: time.Unix(int64(timestamp), 0),
+	}
+	runtime.SetFinalizer(fsys, func(fsys *treeFS) {
+		C.g_object_unref(C.gpointer(fsys.root))
+	})
+	return fsys, nil
+}
+
+// Open implements fs.FS
+func (fsys *treeFS) Open(name string) (fs.File, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	defer runtime.KeepAlive(fsys)
+
+	file, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(file))
+
+	info, err := fsys.stat("open", file, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := fsys.readDir(file, name)
+		if err != nil {
+			return nil, err
+		}
+		return &treeDir{info: info, entries: entries}, nil
+	}
+
+	var cerr *C.GError
+	stream := C.g_file_read(file, nil, &cerr)
+	if stream == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: generateError(cerr)}
+	}
+	return &treeFile{info: info, stream: (*C.GInputStream)(unsafe.Pointer(stream))}, nil
+}
+
+// Stat implements fs.StatFS
+func (fsys *treeFS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.statPath("stat", name, true)
+}
+
+// Lstat returns the fs.FileInfo of name without following a final symbolic
+// link
+func (fsys *treeFS) Lstat(name string) (fs.FileInfo, error) {
+	return fsys.statPath("lstat", name, false)
+}
+
+// ReadLink returns the target of the symbolic link name
+func (fsys *treeFS) ReadLink(name string) (string, error) {
+	info, err := fsys.statPath("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return info.target, nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (fsys *treeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	defer runtime.KeepAlive(fsys)
+
+	file, err := fsys.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(file))
+
+	info, err := fsys.stat("readdir", file, name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return fsys.readDir(file, name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (fsys *treeFS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return io.ReadAll(f)
+}
+
+// statPath returns the fs.FileInfo of name, following a final symbolic link
+// if follow is true
+func (fsys *treeFS) statPath(op, name string, follow bool) (*treeFileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	defer runtime.KeepAlive(fsys)
+
+	file, err := fsys.lookup(op, name, follow)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(file))
+	return fsys.stat(op, file, name)
+}
+
+// lookup returns a new reference to the file at name, resolving symbolic
+// links of intermediate directories, and of name itself if follow is true
+func (fsys *treeFS) lookup(op, name string, follow bool) (*C.GFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	root := (*C.GFile)(fsys.root)
+	file := (*C.GFile)(C.g_object_ref(C.gpointer(root)))
+	components := splitTreePath(name)
+	links := 0
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+
+		if component == ".." {
+			if parent := C.g_file_get_parent(file); parent != nil {
+				C.g_object_unref(C.gpointer(file))
+				file = parent
+			}
+			continue
+		}
+
+		ccomponent := C.CString(component)
+		child := C.g_file_get_child(file, ccomponent)
+		C.free(unsafe.Pointer(ccomponent))
+		C.g_object_unref(C.gpointer(file))
+		file = child
+
+		if len(components) == 0 && !follow {
+			break
+		}
+
+		info, err := queryTreeFile(op, name, file, "standard::type,standard::symlink-target")
+		if err != nil {
+			C.g_object_unref(C.gpointer(file))
+			return nil, err
+		}
+		if C.g_file_info_get_file_type(info) != C.G_FILE_TYPE_SYMBOLIC_LINK {
+			C.g_object_unref(C.gpointer(info))
+			continue
+		}
+		target := C.GoString(C.g_file_info_get_symlink_target(info))
+		C.g_object_unref(C.gpointer(info))
+
+		links++
+		if links > maxSymlinks {
+			C.g_object_unref(C.gpointer(file))
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+
+		// Resolve the target from the directory holding the link
+		parent := C.g_file_get_parent(file)
+		C.g_object_unref(C.gpointer(file))
+		if strings.HasPrefix(target, "/") {
+			C.g_object_unref(C.gpointer(parent))
+			parent = (*C.GFile)(C.g_object_ref(C.gpointer(root)))
+		}
+		file = parent
+		components = append(splitTreePath(target), components...)
+	}
+	return file, nil
+}
+
+// stat returns the fs.FileInfo of a file of the tree, named after the last
+// element of name
+func (fsys *treeFS) stat(op string, file *C.GFile, name string) (*treeFileInfo, error) {
+	info, err := queryTreeFile(op, name, file, "standard::type,standard::size,standard::symlink-target,unix::mode,unix::uid,unix::gid")
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(info))
+
+	var cerr *C.GError
+	repoFile := C._ostree_repo_file(file)
+	if !isOk(C.ostree_repo_file_ensure_resolved(repoFile, &cerr)) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: generateError(cerr)}
+	}
+
+	sys := &CommitFileSys{
+		UID: fileInfoUint32(info, "unix::uid"),
+		GID: fileInfoUint32(info, "unix::gid"),
+	}
+	var xattrs *C.GVariant
+	if !isOk(C.ostree_repo_file_get_xattrs(repoFile, &xattrs, nil, &cerr)) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: generateError(cerr)}
+	}
+	if xattrs != nil {
+		if C.g_variant_n_children(xattrs) > 0 {
+			sys.Xattrs = xattrsFromVariant(xattrs)
+		}
+		C.g_variant_unref(xattrs)
+	}
+
+	fi := &treeFileInfo{
+		name:    path.Base(name),
+		mode:    fileModeFromUnix(fileInfoUint32(info, "unix::mode")),
+		modTime: fsys.modTime,
+		sys:     sys,
+	}
+	switch C.g_file_info_get_file_type(info) {
+	case C.G_FILE_TYPE_DIRECTORY:
+		sys.Checksum = C.GoString(C.ostree_repo_file_tree_get_contents_checksum(repoFile))
+		sys.MetaChecksum = C.GoString(C.ostree_repo_file_tree_get_metadata_checksum(repoFile))
+	case C.G_FILE_TYPE_SYMBOLIC_LINK:
+		sys.Checksum = C.GoString(C.ostree_repo_file_get_checksum(repoFile))
+		fi.target = C.GoString(C.g_file_info_get_symlink_target(info))
+	default:
+		sys.Checksum = C.GoString(C.ostree_repo_file_get_checksum(repoFile))
+		fi.size = int64(C.g_file_info_get_size(info))
+	}
+	return fi, nil
+}
+
+// readDir returns the entries of a directory of the tree, sorted by name
+func (fsys *treeFS) readDir(dir *C.GFile, name string) ([]fs.DirEntry, error) {
+	var cerr *C.GError
+	cattrs := C.CString("standard::name")
+	defer C.free(unsafe.Pointer(cattrs))
+	enumerator := C.g_file_enumerate_children(dir, cattrs, C.G_FILE_QUERY_INFO_NOFOLLOW_SYMLINKS, nil, &cerr)
+	if enumerator == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: generateError(cerr)}
+	}
+	defer C.g_object_unref(C.gpointer(enumerator))
+
+	var entries []fs.DirEntry
+	for {
+		info := C.g_file_enumerator_next_file(enumerator, nil, &cerr)
+		if info == nil {
+			if cerr != nil {
+				return nil, &fs.PathError{Op: "readdir", Path: name, Err: generateError(cerr)}
+			}
+			break
+		}
+		cchildName := C.g_file_info_get_name(info)
+		child := C.g_file_get_child(dir, cchildName)
+		childInfo, err := fsys.stat("readdir", child, path.Join(name, C.GoString(cchildName)))
+		C.g_object_unref(C.gpointer(child))
+		C.g_object_unref(C.gpointer(info))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(childInfo))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// queryTreeFile queries attributes of a file of the tree without following
+// symbolic links, reporting a missing file as fs.ErrNotExist
+func queryTreeFile(op, name string, file *C.GFile, attributes string) (*C.GFileInfo, error) {
+	cattrs := C.CString(attributes)
+	defer C.free(unsafe.Pointer(cattrs))
+
+	var cerr *C.GError
+	info := C.g_file_query_info(file, cattrs, C.G_FILE_QUERY_INFO_NOFOLLOW_SYMLINKS, nil, &cerr)
+	if info == nil {
+		if isOk(C.g_error_matches(cerr, C.g_io_error_quark(), C.gint(C.G_IO_ERROR_NOT_FOUND))) ||
+			isOk(C.g_error_matches(cerr, C.g_io_error_quark(), C.gint(C.G_IO_ERROR_NOT_DIRECTORY))) {
+			C.g_error_free(cerr)
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, &fs.PathError{Op: op, Path: name, Err: generateError(cerr)}
+	}
+	return info, nil
+}
+
+// splitTreePath splits a "/"-separated path into its elements, dropping
+// empty and "." elements
+func splitTreePath(name string) []string {
+	var components []string
+	for _, component := range strings.Split(name, "/") {
+		if component != "" && component != "." {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// fileModeFromUnix converts a unix mode to an fs.FileMode
+func fileModeFromUnix(mode uint32) fs.FileMode {
+	fileMode := fs.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		fileMode |= fs.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= fs.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= fs.ModeSticky
+	}
+	switch mode & modeTypeMask {
+	case modeTypeDir:
+		fileMode |= fs.ModeDir
+	case modeTypeSymlink:
+		fileMode |= fs.ModeSymlink
+	}
+	return fileMode
+}
+
+// treeFileInfo implements fs.FileInfo for the files of a commit
+type treeFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	target  string
+	sys     *CommitFileSys
+}
+
+func (fi *treeFileInfo) Name() string       { return fi.name }
+func (fi *treeFileInfo) Size() int64        { return fi.size }
+func (fi *treeFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *treeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *treeFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *treeFileInfo) Sys() interface{}   { return fi.sys }
+
+// treeFile is an open regular file of a commit
+type treeFile struct {
+	info   *treeFileInfo
+	stream *C.GInputStream
+}
+
+// Stat implements fs.File
+func (f *treeFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read implements fs.File
+func (f *treeFile) Read(p []byte) (int, error) {
+	if f.stream == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return (&inputStreamReader{f.stream}).Read(p)
+}
+
+// Close implements fs.File
+func (f *treeFile) Close() error {
+	if f.stream == nil {
+		return &fs.PathError{Op: "close", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	C.g_object_unref(C.gpointer(f.stream))
+	f.stream = nil
+	return nil
+}
+
+// treeDir is an open directory of a commit
+type treeDir struct {
+	info    *treeFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+// Stat implements fs.File
+func (d *treeDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read implements fs.File
+func (d *treeDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// Close implements fs.File
+func (d *treeDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := d.entries[d.offset:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(entries) {
+			entries = entries[:n]
+		}
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
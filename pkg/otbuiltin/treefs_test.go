@@ -0,0 +1,109 @@
+package otbuiltin
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOpenCommitFS(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	opts := NewCommitOptions()
+	opts.FSMetadata = func(name string, info fs.FileInfo, meta FileInfo) (FileInfo, map[string][]byte, error) {
+		if name == "etc/motd" {
+			meta.UID = 1000
+			meta.GID = 100
+			return meta, map[string][]byte{"user.test": []byte("value")}, nil
+		}
+		return meta, nil, nil
+	}
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+	_, err = repo.CommitFS(fstest.MapFS{
+		"etc/motd":       {Data: []byte("welcome\n"), Mode: 0644},
+		"etc/issue":      {Data: []byte("issue\n"), Mode: 0600},
+		"usr/lib/os":     {Data: []byte("ostree\n"), Mode: 0644},
+		"lib":            {Data: []byte("usr/lib"), Mode: fs.ModeSymlink | 0777},
+		"usr/etc":        {Data: []byte("/etc"), Mode: fs.ModeSymlink | 0777},
+		"usr/lib/parent": {Data: []byte("../../etc/motd"), Mode: fs.ModeSymlink | 0777},
+	}, "tree", opts)
+	if err != nil {
+		t.Fatalf("failed to commit fs: %s", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	fsys, err := repo.OpenCommitFS("tree")
+	if err != nil {
+		t.Fatalf("failed to open commit fs: %s", err)
+	}
+	if err := fstest.TestFS(fsys, "etc/motd", "etc/issue", "usr/lib/os", "lib/os", "usr/etc/motd"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Symbolic links resolve within the tree
+	for _, name := range []string{"etc/motd", "usr/etc/motd", "usr/lib/parent"} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+		if string(data) != "welcome\n" {
+			t.Errorf("unexpected content of %s: %q", name, data)
+		}
+	}
+	if _, err := fs.Stat(fsys, "etc/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	if _, err := fs.Stat(fsys, "etc/motd/child"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	if _, err := fsys.Open("/etc/motd"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected fs.ErrInvalid, got %v", err)
+	}
+
+	info, err := fs.Stat(fsys, "etc/motd")
+	if err != nil {
+		t.Fatalf("failed to stat: %s", err)
+	}
+	sys, ok := info.Sys().(*CommitFileSys)
+	if !ok || len(sys.Checksum) != 64 || sys.UID != 1000 || sys.GID != 100 || string(sys.Xattrs["user.test"]) != "value" {
+		t.Errorf("unexpected metadata %+v", info.Sys())
+	}
+	info, err = fs.Stat(fsys, ".")
+	if err != nil {
+		t.Fatalf("failed to stat root: %s", err)
+	}
+	if sys := info.Sys().(*CommitFileSys); !info.IsDir() || len(sys.Checksum) != 64 || len(sys.MetaChecksum) != 64 {
+		t.Errorf("unexpected root metadata %+v", sys)
+	}
+
+	lfs := fsys.(interface {
+		Lstat(name string) (fs.FileInfo, error)
+		ReadLink(name string) (string, error)
+	})
+	if info, err := lfs.Lstat("lib"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("expected lib to be a symbolic link: %v", err)
+	}
+	if target, err := lfs.ReadLink("usr/etc"); err != nil || target != "/etc" {
+		t.Errorf("unexpected link target %q: %v", target, err)
+	}
+	if _, err := lfs.ReadLink("etc/motd"); err == nil {
+		t.Error("got unexpected nil error reading a regular file as link")
+	}
+
+	if _, err := repo.OpenCommitFS("missing"); err == nil {
+		t.Error("got unexpected nil error opening a missing commit")
+	}
+}
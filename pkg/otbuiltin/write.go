@@ -0,0 +1,478 @@
+package otbuiltin
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+	"unsafe"
+
+	glib "github.com/ostreedev/ostree-go/pkg/glibobject"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <gio/gunixinputstream.h>
+// #include <ostree.h>
+// #include "builtin.go.h"
+import "C"
+
+// ObjectType is the type of an ostree object
+type ObjectType int
+
+// Object types, matching OstreeObjectType
+const (
+	ObjectTypeFile       ObjectType = C.OSTREE_OBJECT_TYPE_FILE
+	ObjectTypeDirTree    ObjectType = C.OSTREE_OBJECT_TYPE_DIR_TREE
+	ObjectTypeDirMeta    ObjectType = C.OSTREE_OBJECT_TYPE_DIR_META
+	ObjectTypeCommit     ObjectType = C.OSTREE_OBJECT_TYPE_COMMIT
+	ObjectTypeTombstone  ObjectType = C.OSTREE_OBJECT_TYPE_TOMBSTONE_COMMIT
+	ObjectTypeCommitMeta ObjectType = C.OSTREE_OBJECT_TYPE_COMMIT_META
+)
+
+// File type bits of FileInfo.Mode, as in st_mode
+const (
+	modeTypeMask    = 0170000
+	modeTypeDir     = 0040000
+	modeTypeRegular = 0100000
+	modeTypeSymlink = 0120000
+)
+
+// FileInfo holds the metadata of a file or directory object
+type FileInfo struct {
+	Mode          uint32 // st_mode, including the file type bits, e.g. syscall.S_IFREG | 0644
+	UID           uint32 // Owner user id
+	GID           uint32 // Owner group id
+	Size          int64  // Size of the content of a regular file
+	SymlinkTarget string // Target of a symbolic link
+}
+
+// DirTreeEntry is a subdirectory of a dirtree object
+type DirTreeEntry struct {
+	TreeChecksum string // Checksum of the dirtree object of the subdirectory
+	MetaChecksum string // Checksum of the dirmeta object of the subdirectory
+}
+
+// CommitObject holds the fields of a commit object
+type CommitObject struct {
+	Parent    string         // Checksum of the parent commit, if any
+	Subject   string         // One line subject
+	Body      string         // Full description
+	Metadata  *glib.GVariant // a{sv} metadata, may be nil
+	Timestamp time.Time      // Commit time, defaults to now
+	RootTree  string         // Checksum of the root dirtree object
+	RootMeta  string         // Checksum of the root dirmeta object
+}
+
+// WriteContent writes a file object read from r into the repo and returns
+// its checksum.  r must provide exactly info.Size bytes for regular files
+// and is not read for symbolic links.  It must be called within a
+// transaction.
+func (repo *Repo) WriteContent(r io.Reader, info FileInfo, xattrs map[string][]byte) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+	defer runtime.KeepAlive(repo)
+
+	fileType := info.Mode & modeTypeMask
+	if fileType != modeTypeRegular && fileType != modeTypeSymlink {
+		return "", errors.New("content objects must be regular files or symbolic links")
+	}
+
+	cinfo := fileInfoToNative(info)
+	defer C.g_object_unref(C.gpointer(cinfo))
+	cxattrs := xattrsToVariant(xattrs)
+	defer C.g_variant_unref(cxattrs)
+
+	// Regular file content is streamed from r through a pipe
+	var input *C.GInputStream
+	var pr *os.File
+	copyErr := make(chan error, 1)
+	if fileType == modeTypeRegular {
+		var pw *os.File
+		var err error
+		if pr, pw, err = os.Pipe(); err != nil {
+			return "", err
+		}
+
+		go func() {
+			_, err := io.CopyN(pw, r, info.Size)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			pw.Close()
+			copyErr <- err
+		}()
+
+		input = C.g_unix_input_stream_new(C.gint(pr.Fd()), C.FALSE)
+		defer C.g_object_unref(C.gpointer(input))
+	} else {
+		copyErr <- nil
+	}
+
+	var cerr *C.GError
+	var objectInput *C.GInputStream
+	var length C.guint64
+	ok := isOk(C.ostree_raw_file_to_content_stream(input, cinfo, cxattrs, &objectInput, &length, nil, &cerr))
+	var ccsum *C.guchar
+	if ok {
+		ok = isOk(C.ostree_repo_write_content(repo.native(), nil, objectInput, length, &ccsum, nil, &cerr))
+		C.g_object_unref(C.gpointer(objectInput))
+	}
+
+	// Closing the read end unblocks the copy if the stream was not read
+	// to the end
+	if pr != nil {
+		pr.Close()
+	}
+	err := <-copyErr
+	if !ok {
+		return "", generateError(cerr)
+	}
+	if err != nil {
+		C.g_free(C.gpointer(ccsum))
+		return "", err
+	}
+	return checksumFromBytes(ccsum), nil
+}
+
+// WriteMetadata writes a metadata object of type objType into the repo and
+// returns its checksum.  It must be called within a transaction.
+func (repo *Repo) WriteMetadata(objType ObjectType, object *glib.GVariant) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+	return repo.writeMetadata(objType, (*C.GVariant)(object.Ptr()))
+}
+
+// WriteDirMeta writes the dirmeta object of a directory with the given
+// ownership, mode and extended attributes, and returns its checksum
+func (repo *Repo) WriteDirMeta(info FileInfo, xattrs map[string][]byte) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+
+	info.Mode = modeTypeDir | info.Mode&^modeTypeMask
+	cinfo := fileInfoToNative(info)
+	defer C.g_object_unref(C.gpointer(cinfo))
+	cxattrs := xattrsToVariant(xattrs)
+	defer C.g_variant_unref(cxattrs)
+
+	dirmeta := C.g_variant_ref_sink(C.ostree_create_directory_metadata(cinfo, cxattrs))
+	defer C.g_variant_unref(dirmeta)
+	return repo.writeMetadata(ObjectTypeDirMeta, dirmeta)
+}
+
+// WriteDirTree writes a dirtree object listing the given files, mapping
+// names to content checksums, and subdirectories, and returns its checksum
+func (repo *Repo) WriteDirTree(files map[string]string, dirs map[string]DirTreeEntry) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+
+	for _, checksum := range files {
+		if err := validateChecksum(checksum); err != nil {
+			return "", err
+		}
+	}
+	for _, dir := range dirs {
+		if err := validateChecksum(dir.TreeChecksum); err != nil {
+			return "", err
+		}
+		if err := validateChecksum(dir.MetaChecksum); err != nil {
+			return "", err
+		}
+	}
+
+	cfileType := C.CString("a(say)")
+	defer C.free(unsafe.Pointer(cfileType))
+	filesBuilder := C.g_variant_builder_new(C._g_variant_type(cfileType))
+	defer C.g_variant_builder_unref(filesBuilder)
+	for _, name := range sortedKeys(files) {
+		C.g_variant_builder_add_value(filesBuilder, newTuple(nameToVariant(name), checksumToVariant(files[name])))
+	}
+
+	cdirType := C.CString("a(sayay)")
+	defer C.free(unsafe.Pointer(cdirType))
+	dirsBuilder := C.g_variant_builder_new(C._g_variant_type(cdirType))
+	defer C.g_variant_builder_unref(dirsBuilder)
+	dirNames := make([]string, 0, len(dirs))
+	for name := range dirs {
+		dirNames = append(dirNames, name)
+	}
+	sort.Strings(dirNames)
+	for _, name := range dirNames {
+		tree := checksumToVariant(dirs[name].TreeChecksum)
+		meta := checksumToVariant(dirs[name].MetaChecksum)
+		C.g_variant_builder_add_value(dirsBuilder, newTuple(nameToVariant(name), tree, meta))
+	}
+
+	dirtree := C.g_variant_ref_sink(newTuple(C.g_variant_builder_end(filesBuilder), C.g_variant_builder_end(dirsBuilder)))
+	defer C.g_variant_unref(dirtree)
+	return repo.writeMetadata(ObjectTypeDirTree, dirtree)
+}
+
+// WriteCommitObject writes a commit object pointing to the given root
+// dirtree and dirmeta objects, and returns its checksum.  Refs are not
+// updated; use Transaction.SetRef.
+func (repo *Repo) WriteCommitObject(commit CommitObject) (string, error) {
+	if !repo.isInitialized() {
+		return "", errors.New("repo not initialized")
+	}
+
+	checksums := []string{commit.RootTree, commit.RootMeta}
+	if commit.Parent != "" {
+		checksums = append(checksums, commit.Parent)
+	}
+	for _, checksum := range checksums {
+		if err := validateChecksum(checksum); err != nil {
+			return "", err
+		}
+	}
+
+	cmetadataType := C.CString("a{sv}")
+	defer C.free(unsafe.Pointer(cmetadataType))
+	var metadata *C.GVariant
+	if commit.Metadata != nil {
+		metadata = (*C.GVariant)(commit.Metadata.Ptr())
+		if !isOk(C.g_variant_is_of_type(metadata, C._g_variant_type(cmetadataType))) {
+			return "", errors.New("commit metadata must be of type a{sv}")
+		}
+	} else {
+		metadata = C.g_variant_new_array(C.g_variant_type_element(C._g_variant_type(cmetadataType)), nil, 0)
+	}
+
+	parent := bytesToVariant(nil)
+	if commit.Parent != "" {
+		parent = checksumToVariant(commit.Parent)
+	}
+
+	timestamp := commit.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	crelatedType := C.CString("a(say)")
+	defer C.free(unsafe.Pointer(crelatedType))
+	related := C.g_variant_new_array(C.g_variant_type_element(C._g_variant_type(crelatedType)), nil, 0)
+
+	csubject := C.CString(commit.Subject)
+	defer C.free(unsafe.Pointer(csubject))
+	cbody := C.CString(commit.Body)
+	defer C.free(unsafe.Pointer(cbody))
+
+	cobject := C.g_variant_ref_sink(newTuple(
+		metadata,
+		parent,
+		related,
+		C.g_variant_new_string((*C.gchar)(csubject)),
+		C.g_variant_new_string((*C.gchar)(cbody)),
+		C.g_variant_new_uint64(C._guint64_from_be(C.guint64(timestamp.Unix()))),
+		checksumToVariant(commit.RootTree),
+		checksumToVariant(commit.RootMeta),
+	))
+	defer C.g_variant_unref(cobject)
+	return repo.writeMetadata(ObjectTypeCommit, cobject)
+}
+
+// ReadCommitObject loads the commit rev, which is a ref or a checksum, and
+// returns its checksum and contents.  The Metadata of the returned commit
+// is always set, so it can be passed back to WriteCommitObject.
+func (repo *Repo) ReadCommitObject(rev string) (string, *CommitObject, error) {
+	defer runtime.KeepAlive(repo)
+	checksum, err := repo.ResolveRev(rev, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+
+	var cerr *C.GError
+	var cobject *C.GVariant
+	if !isOk(C.ostree_repo_load_variant(repo.native(), C.OSTREE_OBJECT_TYPE_COMMIT, cchecksum, &cobject, &cerr)) {
+		return "", nil, generateError(cerr)
+	}
+	defer C.g_variant_unref(cobject)
+
+	commit := &CommitObject{
+		Metadata:  glib.GVariantNew(unsafe.Pointer(C.g_variant_get_child_value(cobject, 0))),
+		Subject:   variantChildString(cobject, 3),
+		Body:      variantChildString(cobject, 4),
+		Timestamp: time.Unix(int64(C.ostree_commit_get_timestamp(cobject)), 0),
+		RootTree:  variantChildChecksum(cobject, 6),
+		RootMeta:  variantChildChecksum(cobject, 7),
+	}
+	if cparent := C.ostree_commit_get_parent(cobject); cparent != nil {
+		commit.Parent = C.GoString((*C.char)(cparent))
+		C.g_free(C.gpointer(cparent))
+	}
+	return checksum, commit, nil
+}
+
+// ReadCommitDetachedMetadata returns the detached metadata of the commit
+// checksum, of type a{sv}, or nil if it has none
+func (repo *Repo) ReadCommitDetachedMetadata(checksum string) (*glib.GVariant, error) {
+	defer runtime.KeepAlive(repo)
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+
+	var cerr *C.GError
+	var cmetadata *C.GVariant
+	if !isOk(C.ostree_repo_read_commit_detached_metadata(repo.native(), cchecksum, &cmetadata, nil, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	if cmetadata == nil {
+		return nil, nil
+	}
+	return glib.GVariantNew(unsafe.Pointer(cmetadata)), nil
+}
+
+// variantChildString returns the string child i of a tuple variant
+func variantChildString(variant *C.GVariant, i int) string {
+	child := C.g_variant_get_child_value(variant, C.gsize(i))
+	defer C.g_variant_unref(child)
+	return C.GoString((*C.char)(C.g_variant_get_string(child, nil)))
+}
+
+// variantChildChecksum returns the binary checksum child i of a tuple
+// variant in hex form
+func variantChildChecksum(variant *C.GVariant, i int) string {
+	child := C.g_variant_get_child_value(variant, C.gsize(i))
+	defer C.g_variant_unref(child)
+	chex := C.ostree_checksum_from_bytes_v(child)
+	defer C.g_free(C.gpointer(chex))
+	return C.GoString(chex)
+}
+
+// writeMetadata writes a metadata object and returns its checksum
+func (repo *Repo) writeMetadata(objType ObjectType, object *C.GVariant) (string, error) {
+	defer runtime.KeepAlive(repo)
+	var cerr *C.GError
+	var ccsum *C.guchar
+	if !isOk(C.ostree_repo_write_metadata(repo.native(), C.OstreeObjectType(objType), nil, object, &ccsum, nil, &cerr)) {
+		return "", generateError(cerr)
+	}
+	return checksumFromBytes(ccsum), nil
+}
+
+// fileInfoToNative converts file metadata to a new GFileInfo
+func fileInfoToNative(info FileInfo) *C.GFileInfo {
+	cinfo := C.g_file_info_new()
+	switch info.Mode & modeTypeMask {
+	case modeTypeDir:
+		C.g_file_info_set_file_type(cinfo, C.G_FILE_TYPE_DIRECTORY)
+	case modeTypeSymlink:
+		C.g_file_info_set_file_type(cinfo, C.G_FILE_TYPE_SYMBOLIC_LINK)
+		ctarget := C.CString(info.SymlinkTarget)
+		C.g_file_info_set_symlink_target(cinfo, ctarget)
+		C.free(unsafe.Pointer(ctarget))
+	default:
+		C.g_file_info_set_file_type(cinfo, C.G_FILE_TYPE_REGULAR)
+		C.g_file_info_set_size(cinfo, C.goffset(info.Size))
+	}
+
+	attrs := []struct {
+		name  string
+		value uint32
+	}{
+		{"unix::mode", info.Mode},
+		{"unix::uid", info.UID},
+		{"unix::gid", info.GID},
+	}
+	for _, attr := range attrs {
+		cname := C.CString(attr.name)
+		C.g_file_info_set_attribute_uint32(cinfo, cname, C.guint32(attr.value))
+		C.free(unsafe.Pointer(cname))
+	}
+	return cinfo
+}
+
+// xattrsToVariant converts extended attributes to a new ostree a(ayay)
+// variant, sorted by name
+func xattrsToVariant(xattrs map[string][]byte) *C.GVariant {
+	ctype := C.CString("a(ayay)")
+	defer C.free(unsafe.Pointer(ctype))
+	builder := C.g_variant_builder_new(C._g_variant_type(ctype))
+	defer C.g_variant_builder_unref(builder)
+
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cname := C.CString(name)
+		nameVariant := C.g_variant_new_bytestring((*C.gchar)(cname))
+		C.free(unsafe.Pointer(cname))
+		C.g_variant_builder_add_value(builder, newTuple(nameVariant, bytesToVariant(xattrs[name])))
+	}
+	return C.g_variant_ref_sink(C.g_variant_builder_end(builder))
+}
+
+// bytesToVariant returns a new floating ay variant holding a copy of data
+func bytesToVariant(data []byte) *C.GVariant {
+	cbyteType := C.CString("y")
+	defer C.free(unsafe.Pointer(cbyteType))
+	if len(data) == 0 {
+		return C.g_variant_new_fixed_array(C._g_variant_type(cbyteType), nil, 0, 1)
+	}
+	return C.g_variant_new_fixed_array(C._g_variant_type(cbyteType), C.gconstpointer(unsafe.Pointer(&data[0])), C.gsize(len(data)), 1)
+}
+
+// nameToVariant returns a new floating s variant
+func nameToVariant(name string) *C.GVariant {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.g_variant_new_string((*C.gchar)(cname))
+}
+
+// newTuple returns a new floating tuple variant of the given children,
+// consuming their floating references
+func newTuple(children ...*C.GVariant) *C.GVariant {
+	carray := (**C.GVariant)(C.malloc(C.size_t(len(children)) * C.size_t(unsafe.Sizeof(children[0]))))
+	defer C.free(unsafe.Pointer(carray))
+	slice := (*[1 << 16]*C.GVariant)(unsafe.Pointer(carray))[:len(children):len(children)]
+	copy(slice, children)
+	return C.g_variant_new_tuple(carray, C.gsize(len(children)))
+}
+
+// validateChecksum checks that checksum is a valid hex SHA256 checksum
+func validateChecksum(checksum string) error {
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+
+	var cerr *C.GError
+	if !isOk(C.ostree_validate_checksum_string(cchecksum, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// checksumToVariant returns a valid hex checksum as a new floating ay variant
+func checksumToVariant(checksum string) *C.GVariant {
+	cchecksum := C.CString(checksum)
+	defer C.free(unsafe.Pointer(cchecksum))
+	return C.ostree_checksum_to_bytes_v(cchecksum)
+}
+
+// checksumFromBytes converts and frees a binary checksum returned by ostree
+func checksumFromBytes(csum *C.guchar) string {
+	defer C.g_free(C.gpointer(csum))
+	chex := C.ostree_checksum_from_bytes(csum)
+	defer C.g_free(C.gpointer(chex))
+	return C.GoString(chex)
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
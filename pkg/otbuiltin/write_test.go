@@ -0,0 +1,146 @@
+package otbuiltin
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteObjects(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	tx, err := repo.BeginTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	content := "hello, world\n"
+	file, err := repo.WriteContent(strings.NewReader(content), FileInfo{Mode: syscall.S_IFREG | 0644, Size: int64(len(content))}, nil)
+	if err != nil {
+		t.Fatalf("failed to write content: %s", err)
+	}
+	link, err := repo.WriteContent(nil, FileInfo{Mode: syscall.S_IFLNK | 0777, SymlinkTarget: "hello"}, nil)
+	if err != nil {
+		t.Fatalf("failed to write symlink: %s", err)
+	}
+	if _, err := repo.WriteContent(strings.NewReader("short"), FileInfo{Mode: syscall.S_IFREG | 0644, Size: 100}, nil); err == nil {
+		t.Error("got unexpected nil error for a short reader")
+	}
+
+	dirMeta, err := repo.WriteDirMeta(FileInfo{Mode: 0755}, nil)
+	if err != nil {
+		t.Fatalf("failed to write dirmeta: %s", err)
+	}
+	subTree, err := repo.WriteDirTree(map[string]string{"link": link}, nil)
+	if err != nil {
+		t.Fatalf("failed to write dirtree: %s", err)
+	}
+	rootTree, err := repo.WriteDirTree(map[string]string{"hello": file}, map[string]DirTreeEntry{"sub": {subTree, dirMeta}})
+	if err != nil {
+		t.Fatalf("failed to write dirtree: %s", err)
+	}
+	if _, err := repo.WriteDirTree(map[string]string{"bad": "not-a-checksum"}, nil); err == nil {
+		t.Error("got unexpected nil error for an invalid checksum")
+	}
+
+	commit, err := repo.WriteCommitObject(CommitObject{
+		Subject:  "generated",
+		RootTree: rootTree,
+		RootMeta: dirMeta,
+	})
+	if err != nil {
+		t.Fatalf("failed to write commit: %s", err)
+	}
+	if err := tx.SetRef("", "generated", commit); err != nil {
+		t.Fatalf("failed to set ref: %s", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+
+	// Read the generated tree back
+	var buf bytes.Buffer
+	if err := repo.Export("generated", &buf, NewExportOptions()); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	headers, contents := readTar(t, &buf)
+	if contents["hello"] != content {
+		t.Errorf("unexpected content %q", contents["hello"])
+	}
+	if hdr := headers["sub/link"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "hello" {
+		t.Errorf("unexpected symlink entry %+v", hdr)
+	}
+	if hdr := headers["sub/"]; hdr == nil || hdr.Mode != 0755 {
+		t.Errorf("unexpected directory entry %+v", hdr)
+	}
+}
+
+func TestReadCommitObject(t *testing.T) {
+	repo, err := NewTempRepo()
+	if err != nil {
+		t.Fatalf("failed to create temp repo: %s", err)
+	}
+	defer repo.Close()
+
+	commitDir, err := ioutil.TempDir("", "otbuiltin-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(commitDir)
+
+	first, err := repo.Commit(commitDir, "main", NewCommitOptions())
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(commitDir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	opts := NewCommitOptions()
+	opts.Subject = "second"
+	opts.Body = "second commit"
+	opts.AddMetadataString = []string{"version=2"}
+	second, err := repo.Commit(commitDir, "main", opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+
+	// Both refs and checksums resolve to the commit
+	for _, rev := range []string{"main", second.Checksum} {
+		checksum, object, err := repo.ReadCommitObject(rev)
+		if err != nil {
+			t.Fatalf("failed to read commit %s: %s", rev, err)
+		}
+		if checksum != second.Checksum || object.Parent != first.Checksum || object.Subject != "second" || object.Body != "second commit" || object.RootTree != second.RootTree || len(object.RootMeta) != 64 {
+			t.Errorf("unexpected commit %s %+v", checksum, object)
+		}
+		if object.Timestamp.IsZero() {
+			t.Error("commit has no timestamp")
+		}
+		if version, err := object.Metadata.LookupString("version"); err != nil || version != "2" {
+			t.Errorf("expected metadata version=2, got %q: %v", version, err)
+		}
+	}
+
+	_, object, err := repo.ReadCommitObject(first.Checksum)
+	if err != nil {
+		t.Fatalf("failed to read commit: %s", err)
+	}
+	if object.Parent != "" || object.Metadata == nil || object.Metadata.TypeString() != "a{sv}" {
+		t.Errorf("unexpected first commit %+v", object)
+	}
+
+	if _, _, err := repo.ReadCommitObject("nonexistent"); err == nil {
+		t.Error("got unexpected nil error reading a nonexistent commit")
+	}
+}
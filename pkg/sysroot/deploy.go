@@ -0,0 +1,372 @@
+package sysroot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"unsafe"
+
+	"github.com/ostreedev/ostree-go/pkg/kargs"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "sysroot.go.h"
+import "C"
+
+// SimpleWriteFlags controls how SimpleWriteDeployment orders and retains
+// existing deployments
+type SimpleWriteFlags uint
+
+const (
+	// SimpleWriteRetain keeps all existing deployments
+	SimpleWriteRetain SimpleWriteFlags = C.OSTREE_SYSROOT_SIMPLE_WRITE_DEPLOYMENT_FLAGS_RETAIN
+	// SimpleWriteNotDefault adds the new deployment after the booted one
+	// rather than making it the default
+	SimpleWriteNotDefault SimpleWriteFlags = C.OSTREE_SYSROOT_SIMPLE_WRITE_DEPLOYMENT_FLAGS_NOT_DEFAULT
+	// SimpleWriteNoClean skips the cleanup of unused objects and deployments
+	SimpleWriteNoClean SimpleWriteFlags = C.OSTREE_SYSROOT_SIMPLE_WRITE_DEPLOYMENT_FLAGS_NO_CLEAN
+	// SimpleWriteRetainPending keeps deployments newer than the booted one
+	SimpleWriteRetainPending SimpleWriteFlags = C.OSTREE_SYSROOT_SIMPLE_WRITE_DEPLOYMENT_FLAGS_RETAIN_PENDING
+	// SimpleWriteRetainRollback keeps deployments older than the booted one
+	SimpleWriteRetainRollback SimpleWriteFlags = C.OSTREE_SYSROOT_SIMPLE_WRITE_DEPLOYMENT_FLAGS_RETAIN_ROLLBACK
+)
+
+// InitFS creates the toplevel directories of a new sysroot at sysrootPath and
+// initializes its repository and deployment directories, like
+// `ostree admin init-fs`
+func InitFS(sysrootPath string) error {
+	if sysrootPath == "" {
+		return errors.New("empty path")
+	}
+
+	dirs := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"boot", 0755},
+		{"dev", 0755},
+		{"home", 0755},
+		{"proc", 0755},
+		{"run", 0755},
+		{"sys", 0755},
+		{"root", 0700},
+		{"tmp", 01777},
+	}
+	for _, dir := range dirs {
+		dirPath := path.Join(sysrootPath, dir.name)
+		if err := os.MkdirAll(dirPath, dir.mode); err != nil {
+			return err
+		}
+		// Apply the mode explicitly so the umask doesn't drop the sticky bit
+		if err := os.Chmod(dirPath, dir.mode); err != nil {
+			return err
+		}
+	}
+
+	cpath := C.CString(sysrootPath)
+	defer C.free(unsafe.Pointer(cpath))
+	file := C.g_file_new_for_path(cpath)
+	defer C.g_object_unref(C.gpointer(file))
+	csysroot := C.ostree_sysroot_new(file)
+	defer C.g_object_unref(C.gpointer(csysroot))
+
+	var cerr *C.GError
+	if !isOk(C.ostree_sysroot_ensure_initialized(csysroot, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// Lock acquires the exclusive sysroot lock, blocking until it is available
+func (s *Sysroot) Lock() error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+
+	var cerr *C.GError
+	if !isOk(C.ostree_sysroot_lock(s.native(), &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// Unlock releases the sysroot lock
+func (s *Sysroot) Unlock() {
+	if !s.isInitialized() {
+		return
+	}
+	C.ostree_sysroot_unlock(s.native())
+}
+
+// InitOsname creates the stateroot directories for a new operating system
+func (s *Sysroot) InitOsname(osname string) error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+
+	cosname := C.CString(osname)
+	defer C.free(unsafe.Pointer(cosname))
+
+	var cerr *C.GError
+	if !isOk(C.ostree_sysroot_init_osname(s.native(), cosname, nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return s.Load()
+}
+
+// MergeDeployment returns the deployment whose /etc and kernel arguments a
+// new deployment of osname should inherit, or nil if there is none
+func (s *Sysroot) MergeDeployment(osname string) *Deployment {
+	if !s.isInitialized() {
+		return nil
+	}
+
+	var cosname *C.char
+	if osname != "" {
+		cosname = C.CString(osname)
+		defer C.free(unsafe.Pointer(cosname))
+	}
+
+	cdeployment := C.ostree_sysroot_get_merge_deployment(s.native(), cosname)
+	if cdeployment == nil {
+		return nil
+	}
+	defer C.g_object_unref(C.gpointer(cdeployment))
+	return deploymentFromNative(cdeployment)
+}
+
+// Deploy checks out rev from the sysroot repository as a new deployment of
+// osname.  origin is the refspec the deployment tracks and kernelArgs
+// overrides the kernel arguments; when either is empty, the origin or kernel
+// arguments of the merge deployment are kept.  The deployment is not bootable
+// until it is written with WriteDeployments or SimpleWriteDeployment.
+func (s *Sysroot) Deploy(osname, rev, origin string, kernelArgs []string) (*Deployment, error) {
+	return s.deployTree(osname, rev, origin, kernelArgs, false)
+}
+
+// Stage is like Deploy, but queues the deployment to be finalized when the
+// booted system shuts down.  The sysroot must be the booted one.
+func (s *Sysroot) Stage(osname, rev, origin string, kernelArgs []string) (*Deployment, error) {
+	return s.deployTree(osname, rev, origin, kernelArgs, true)
+}
+
+// deployTree implements Deploy and Stage
+func (s *Sysroot) deployTree(osname, rev, origin string, kernelArgs []string, stage bool) (*Deployment, error) {
+	if !s.isInitialized() {
+		return nil, errors.New("sysroot not initialized")
+	}
+	if osname == "" {
+		return nil, errors.New("an osname must be specified")
+	}
+
+	cosname := C.CString(osname)
+	defer C.free(unsafe.Pointer(cosname))
+	crev := C.CString(rev)
+	defer C.free(unsafe.Pointer(crev))
+
+	var cerr *C.GError
+	var checksum *C.char
+	if !isOk(C.ostree_repo_resolve_rev(C.ostree_sysroot_repo(s.native()), crev, C.FALSE, &checksum, &cerr)) {
+		return nil, generateError(cerr)
+	}
+	defer C.g_free(C.gpointer(checksum))
+
+	var merge *C.OstreeDeployment
+	if mergeDeployment := s.MergeDeployment(osname); mergeDeployment != nil {
+		merge = mergeDeployment.native()
+		defer runtime.KeepAlive(mergeDeployment)
+	}
+
+	// Without an origin, keep tracking the one of the merge deployment
+	var corigin *C.GKeyFile
+	if origin != "" {
+		crefspec := C.CString(origin)
+		defer C.free(unsafe.Pointer(crefspec))
+		corigin = C.ostree_sysroot_origin_new_from_refspec(s.native(), crefspec)
+		defer C.g_key_file_unref(corigin)
+	} else if merge != nil {
+		corigin = C.ostree_deployment_get_origin(merge)
+	}
+
+	// A NULL argv makes ostree reuse the merge deployment's kernel arguments
+	var ckargs **C.char
+	if len(kernelArgs) > 0 {
+		ckargs = newStrv(kernelArgs)
+		defer C.g_strfreev((**C.gchar)(unsafe.Pointer(ckargs)))
+	}
+
+	var cdeployment *C.OstreeDeployment
+	var r C.gboolean
+	if stage {
+		r = C.ostree_sysroot_stage_tree(s.native(), cosname, checksum, corigin, merge, ckargs, &cdeployment, nil, &cerr)
+	} else {
+		r = C.ostree_sysroot_deploy_tree(s.native(), cosname, checksum, corigin, merge, ckargs, &cdeployment, nil, &cerr)
+	}
+	if !isOk(r) {
+		return nil, generateError(cerr)
+	}
+	defer C.g_object_unref(C.gpointer(cdeployment))
+
+	if stage {
+		if err := s.Load(); err != nil {
+			return nil, err
+		}
+	}
+	return deploymentFromNative(cdeployment), nil
+}
+
+// WriteDeployments replaces the deployment list of the sysroot with the
+// given one, in boot order: the first deployment becomes the default, the
+// next one the rollback target, and so on.  Deployments missing from the
+// list are garbage collected.
+func (s *Sysroot) WriteDeployments(deployments []*Deployment) error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+
+	cdeployments := C.g_ptr_array_new()
+	defer C.g_ptr_array_unref(cdeployments)
+	for _, d := range deployments {
+		if d == nil {
+			return errors.New("nil deployment")
+		}
+		C.g_ptr_array_add(cdeployments, C.gpointer(d.native()))
+	}
+
+	var cerr *C.GError
+	r := C.ostree_sysroot_write_deployments(s.native(), cdeployments, nil, &cerr)
+	runtime.KeepAlive(deployments)
+	if !isOk(r) {
+		return generateError(cerr)
+	}
+	return s.Load()
+}
+
+// SimpleWriteDeployment makes newDeployment the default deployment of
+// osname and writes the resulting deployment list.  Unless flags say
+// otherwise, the previous default is kept as the rollback target and older
+// deployments of osname are removed.
+func (s *Sysroot) SimpleWriteDeployment(osname string, newDeployment, mergeDeployment *Deployment, flags SimpleWriteFlags) error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+	if newDeployment == nil {
+		return errors.New("nil deployment")
+	}
+
+	var cosname *C.char
+	if osname != "" {
+		cosname = C.CString(osname)
+		defer C.free(unsafe.Pointer(cosname))
+	}
+
+	var cerr *C.GError
+	r := C.ostree_sysroot_simple_write_deployment(s.native(), cosname, newDeployment.native(), mergeDeployment.native(),
+		C.OstreeSysrootSimpleWriteDeploymentFlags(flags), nil, &cerr)
+	runtime.KeepAlive(newDeployment)
+	runtime.KeepAlive(mergeDeployment)
+	if !isOk(r) {
+		return generateError(cerr)
+	}
+	return s.Load()
+}
+
+// Undeploy removes the deployment at the given index from the boot order
+// and garbage collects it.  The booted deployment cannot be removed.
+func (s *Sysroot) Undeploy(index int) error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+
+	deployments := s.Deployments()
+	if index < 0 || index >= len(deployments) {
+		return fmt.Errorf("out of range deployment index %d, expected < %d", index, len(deployments))
+	}
+
+	target := deployments[index]
+	if booted := s.BootedDeployment(); booted != nil {
+		r := C.ostree_deployment_equal(C.gconstpointer(booted.native()), C.gconstpointer(target.native()))
+		runtime.KeepAlive(booted)
+		if isOk(r) {
+			return fmt.Errorf("cannot undeploy currently booted deployment %d", index)
+		}
+	}
+
+	return s.WriteDeployments(append(deployments[:index], deployments[index+1:]...))
+}
+
+// SetKargs writes a copy of the deployment with its kernel arguments
+// replaced by k, in place of the original one in the boot order
+func (s *Sysroot) SetKargs(d *Deployment, k *kargs.Kargs) error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+	if d == nil {
+		return errors.New("nil deployment")
+	}
+
+	ckargs := newStrv(k.Strings())
+	defer C.g_strfreev((**C.gchar)(unsafe.Pointer(ckargs)))
+
+	var cerr *C.GError
+	r := C.ostree_sysroot_deployment_set_kargs(s.native(), d.native(), ckargs, nil, &cerr)
+	runtime.KeepAlive(d)
+	if !isOk(r) {
+		return generateError(cerr)
+	}
+	return s.Load()
+}
+
+// SetKargsInPlace rewrites the boot loader entry of the deployment with the
+// kernel arguments k, without creating a new deployment, like
+// `ostree admin kargs edit-in-place`
+func (s *Sysroot) SetKargsInPlace(d *Deployment, k *kargs.Kargs) error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+	if d == nil {
+		return errors.New("nil deployment")
+	}
+
+	ckargs := C.CString(k.String())
+	defer C.free(unsafe.Pointer(ckargs))
+
+	var cerr *C.GError
+	r := C.ostree_sysroot_deployment_set_kargs_in_place(s.native(), d.native(), ckargs, nil, &cerr)
+	runtime.KeepAlive(d)
+	if !isOk(r) {
+		return generateError(cerr)
+	}
+	return s.Load()
+}
+
+// newStrv converts a Go string slice to a NULL-terminated C string array,
+// to be freed with g_strfreev
+func newStrv(strs []string) **C.char {
+	cstrv := C._strv_new(C.int(len(strs)))
+	for i, str := range strs {
+		cstr := C.CString(str)
+		C._strv_set(cstrv, C.int(i), cstr)
+		C.free(unsafe.Pointer(cstr))
+	}
+	return cstrv
+}
+
+// Cleanup deletes deployment directories, boot files and repository objects
+// that are no longer referenced by any deployment
+func (s *Sysroot) Cleanup() error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+
+	var cerr *C.GError
+	if !isOk(C.ostree_sysroot_cleanup(s.native(), nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package sysroot
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ostreedev/ostree-go/pkg/otbuiltin"
+)
+
+// commitBootableTree commits a minimal tree with a kernel and initramfs to the
+// repository of the sysroot at sysrootDir, on the given branch
+func commitBootableTree(t *testing.T, baseDir, sysrootDir, branch, subject string) string {
+	treeDir, err := ioutil.TempDir(baseDir, "tree-")
+	if err != nil {
+		t.Fatalf("failed to create tree dir: %s", err)
+	}
+
+	modulesDir := path.Join(treeDir, "usr", "lib", "modules", "5.0.0")
+	if err := os.MkdirAll(modulesDir, 0755); err != nil {
+		t.Fatalf("failed to create modules dir: %s", err)
+	}
+	if err := os.MkdirAll(path.Join(treeDir, "usr", "etc"), 0755); err != nil {
+		t.Fatalf("failed to create usr/etc: %s", err)
+	}
+	files := map[string]string{
+		path.Join(modulesDir, "vmlinuz"):               "kernel",
+		path.Join(modulesDir, "initramfs.img"):         "initramfs",
+		path.Join(treeDir, "usr", "etc", "os-release"): "NAME=testos\n",
+		path.Join(treeDir, "usr", "bin", "sh"):         subject,
+	}
+	for file, contents := range files {
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatalf("failed to create dir for %q: %s", file, err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write %q: %s", file, err)
+		}
+	}
+
+	repo, err := otbuiltin.OpenRepo(path.Join(sysrootDir, "ostree", "repo"))
+	if err != nil {
+		t.Fatalf("failed to open sysroot repo: %s", err)
+	}
+	if _, err := repo.PrepareTransaction(); err != nil {
+		t.Fatalf("failed to prepare transaction: %s", err)
+	}
+	opts := otbuiltin.NewCommitOptions()
+	opts.Subject = subject
+	result, err := repo.Commit(treeDir, branch, opts)
+	if err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	if _, err := repo.CommitTransaction(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err)
+	}
+	return result.Checksum
+}
+
+// newTestSysroot creates and opens a sysroot with an initialized osname
+func newTestSysroot(t *testing.T, baseDir, osname string) *Sysroot {
+	sysrootDir := path.Join(baseDir, "sysroot")
+	if err := InitFS(sysrootDir); err != nil {
+		t.Fatalf("failed to init sysroot: %s", err)
+	}
+	s, err := Open(sysrootDir)
+	if err != nil {
+		t.Fatalf("failed to open sysroot: %s", err)
+	}
+	if err := s.InitOsname(osname); err != nil {
+		t.Fatalf("failed to init osname: %s", err)
+	}
+	return s
+}
+
+func TestInitFS(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "sysroot-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	if err := InitFS(baseDir); err != nil {
+		t.Fatalf("failed to init sysroot: %s", err)
+	}
+	for _, dir := range []string{"boot", "tmp", "ostree/repo", "ostree/deploy"} {
+		if _, err := os.Stat(path.Join(baseDir, dir)); err != nil {
+			t.Errorf("missing %s: %s", dir, err)
+		}
+	}
+	if fi, err := os.Stat(path.Join(baseDir, "tmp")); err == nil && fi.Mode()&os.ModeSticky == 0 {
+		t.Errorf("expected sticky tmp, got mode %s", fi.Mode())
+	}
+}
+
+func TestDeployRollbackUndeploy(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "sysroot-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	osname := "testos"
+	branch := "testos/stable"
+	s := newTestSysroot(t, baseDir, osname)
+	defer s.Close()
+
+	if err := s.Lock(); err != nil {
+		t.Fatalf("failed to lock sysroot: %s", err)
+	}
+	defer s.Unlock()
+
+	// Deploy a first commit
+	firstChecksum := commitBootableTree(t, baseDir, s.Path(), branch, "first")
+	first, err := s.Deploy(osname, branch, branch, []string{"root=/dev/vda1", "quiet"})
+	if err != nil {
+		t.Fatalf("failed to deploy: %s", err)
+	}
+	if first.Csum() != firstChecksum {
+		t.Errorf("expected deployed csum %q, got %q", firstChecksum, first.Csum())
+	}
+	if n := len(s.Deployments()); n != 0 {
+		t.Fatalf("expected deployment to be pending until written, got %d deployments", n)
+	}
+	if err := s.SimpleWriteDeployment(osname, first, nil, 0); err != nil {
+		t.Fatalf("failed to write deployment: %s", err)
+	}
+
+	deployments := s.Deployments()
+	if len(deployments) != 1 {
+		t.Fatalf("expected 1 deployment, got %d", len(deployments))
+	}
+	if deployments[0].OriginRefspec() != branch {
+		t.Errorf("expected refspec %q, got %q", branch, deployments[0].OriginRefspec())
+	}
+	if options := deployments[0].BootConfig().Get("options"); !strings.Contains(options, "quiet") {
+		t.Errorf("expected kernel arguments to be applied, got %q", options)
+	}
+
+	// Deploy a second commit, keeping the first as rollback target
+	secondChecksum := commitBootableTree(t, baseDir, s.Path(), branch, "second")
+	merge := s.MergeDeployment(osname)
+	if merge == nil {
+		t.Fatal("expected a merge deployment")
+	}
+	second, err := s.Deploy(osname, branch, "", nil)
+	if err != nil {
+		t.Fatalf("failed to deploy: %s", err)
+	}
+	if err := s.SimpleWriteDeployment(osname, second, merge, SimpleWriteRetain); err != nil {
+		t.Fatalf("failed to write deployment: %s", err)
+	}
+
+	deployments = s.Deployments()
+	if len(deployments) != 2 {
+		t.Fatalf("expected 2 deployments, got %d", len(deployments))
+	}
+	if deployments[0].Csum() != secondChecksum {
+		t.Errorf("expected new default %q, got %q", secondChecksum, deployments[0].Csum())
+	}
+	if deployments[1].Csum() != firstChecksum {
+		t.Errorf("expected rollback %q, got %q", firstChecksum, deployments[1].Csum())
+	}
+	if options := deployments[0].BootConfig().Get("options"); !strings.Contains(options, "quiet") {
+		t.Errorf("expected kernel arguments to be inherited, got %q", options)
+	}
+	if deployments[0].OriginRefspec() != branch {
+		t.Errorf("expected refspec %q to be inherited, got %q", branch, deployments[0].OriginRefspec())
+	}
+
+	// An empty but non-nil kernelArgs also keeps the merge deployment's
+	// arguments; the pending deployment is discarded again below
+	third, err := s.Deploy(osname, branch, "", []string{})
+	if err != nil {
+		t.Fatalf("failed to deploy: %s", err)
+	}
+	if options := third.BootConfig().Get("options"); !strings.Contains(options, "quiet") {
+		t.Errorf("expected kernel arguments to be inherited, got %q", options)
+	}
+	if third.OriginRefspec() != branch {
+		t.Errorf("expected refspec %q to be inherited, got %q", branch, third.OriginRefspec())
+	}
+
+	// Swap default and rollback
+	if err := s.WriteDeployments([]*Deployment{deployments[1], deployments[0]}); err != nil {
+		t.Fatalf("failed to write deployments: %s", err)
+	}
+	deployments = s.Deployments()
+	if len(deployments) != 2 || deployments[0].Csum() != firstChecksum {
+		t.Fatalf("expected %q to be the default deployment", firstChecksum)
+	}
+
+	// Remove the rollback deployment
+	if err := s.Undeploy(1); err != nil {
+		t.Fatalf("failed to undeploy: %s", err)
+	}
+	deployments = s.Deployments()
+	if len(deployments) != 1 || deployments[0].Csum() != firstChecksum {
+		t.Fatalf("expected only %q to remain deployed", firstChecksum)
+	}
+	if err := s.Undeploy(1); err == nil {
+		t.Fatal("got unexpected nil error for out of range index")
+	}
+
+	if err := s.Cleanup(); err != nil {
+		t.Fatalf("failed to clean up: %s", err)
+	}
+}
+
+func TestStageNotBooted(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "sysroot-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	osname := "testos"
+	branch := "testos/stable"
+	s := newTestSysroot(t, baseDir, osname)
+	defer s.Close()
+
+	commitBootableTree(t, baseDir, s.Path(), branch, "first")
+	if _, err := s.Stage(osname, branch, branch, nil); err == nil {
+		t.Fatal("got unexpected nil error staging on a non-booted sysroot")
+	}
+}
+
+func TestDeploymentKargs(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "sysroot-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	osname := "testos"
+	branch := "testos/stable"
+	s := newTestSysroot(t, baseDir, osname)
+	defer s.Close()
+
+	commitBootableTree(t, baseDir, s.Path(), branch, "first")
+	d, err := s.Deploy(osname, branch, branch, []string{"root=/dev/vda1", "console=tty0", "console=ttyS0"})
+	if err != nil {
+		t.Fatalf("failed to deploy: %s", err)
+	}
+	if err := s.SimpleWriteDeployment(osname, d, nil, 0); err != nil {
+		t.Fatalf("failed to write deployment: %s", err)
+	}
+
+	// Edit the kernel arguments in place
+	d = s.Deployments()[0]
+	k := d.Kargs()
+	if got := k.Get("console"); len(got) != 2 {
+		t.Fatalf("expected 2 console arguments, got %q", got)
+	}
+	k.AppendIfMissing("quiet")
+	if err := k.Delete("console=tty0"); err != nil {
+		t.Fatalf("failed to delete karg: %s", err)
+	}
+	if err := s.SetKargsInPlace(d, k); err != nil {
+		t.Fatalf("failed to set kargs in place: %s", err)
+	}
+
+	k = s.Deployments()[0].Kargs()
+	if !k.Contains("quiet") || k.Contains("console=tty0") || !k.Contains("console=ttyS0") {
+		t.Errorf("unexpected kernel arguments after in place edit %q", k)
+	}
+
+	// Write a new deployment with different kernel arguments
+	if err := k.Replace("root=/dev/vda2"); err != nil {
+		t.Fatalf("failed to replace karg: %s", err)
+	}
+	if err := s.SetKargs(s.Deployments()[0], k); err != nil {
+		t.Fatalf("failed to set kargs: %s", err)
+	}
+
+	deployments := s.Deployments()
+	if len(deployments) != 1 {
+		t.Fatalf("expected 1 deployment, got %d", len(deployments))
+	}
+	if got := deployments[0].Kargs().Get("root"); len(got) != 1 || got[0] != "/dev/vda2" {
+		t.Errorf("unexpected root argument %q", got)
+	}
+}
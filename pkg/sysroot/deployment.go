@@ -0,0 +1,218 @@
+package sysroot
+
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/ostreedev/ostree-go/pkg/kargs"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "sysroot.go.h"
+import "C"
+
+// UnlockedState describes whether, and how, a deployment has been unlocked
+// for local modifications of /usr
+type UnlockedState int
+
+const (
+	// UnlockedNone means /usr is read-only
+	UnlockedNone UnlockedState = C.OSTREE_DEPLOYMENT_UNLOCKED_NONE
+	// UnlockedDevelopment means /usr has a transient writable overlay
+	UnlockedDevelopment UnlockedState = C.OSTREE_DEPLOYMENT_UNLOCKED_DEVELOPMENT
+	// UnlockedHotfix means /usr has a writable overlay persisting across reboots
+	UnlockedHotfix UnlockedState = C.OSTREE_DEPLOYMENT_UNLOCKED_HOTFIX
+	// UnlockedTransient means /usr has a writable overlay discarded on reboot
+	UnlockedTransient UnlockedState = C.OSTREE_DEPLOYMENT_UNLOCKED_TRANSIENT
+)
+
+// String returns the ostree name of the unlocked state
+func (u UnlockedState) String() string {
+	return C.GoString(C.ostree_deployment_unlocked_state_to_string(C.OstreeDeploymentUnlockedState(u)))
+}
+
+// Deployment represents a single deployment of a sysroot
+type Deployment struct {
+	ptr unsafe.Pointer
+}
+
+// deploymentFromNative takes a C ostree deployment, adds a reference to it
+// and converts it to a Go struct.  The reference is dropped when the Go
+// struct is garbage collected.
+func deploymentFromNative(cd *C.OstreeDeployment) *Deployment {
+	if cd == nil {
+		return nil
+	}
+	C.g_object_ref(C.gpointer(cd))
+	d := &Deployment{unsafe.Pointer(cd)}
+	runtime.SetFinalizer(d, (*Deployment).unref)
+	return d
+}
+
+// unref drops the reference held on the C deployment
+func (d *Deployment) unref() {
+	C.g_object_unref(C.gpointer(d.ptr))
+}
+
+// native converts an ostree deployment struct to its C equivalent
+func (d *Deployment) native() *C.OstreeDeployment {
+	if d == nil {
+		return nil
+	}
+	return (*C.OstreeDeployment)(d.ptr)
+}
+
+// Osname returns the name of the stateroot (OS) the deployment belongs to
+func (d *Deployment) Osname() string {
+	defer runtime.KeepAlive(d)
+	return C.GoString(C.ostree_deployment_get_osname(d.native()))
+}
+
+// Csum returns the checksum of the commit that was deployed
+func (d *Deployment) Csum() string {
+	defer runtime.KeepAlive(d)
+	return C.GoString(C.ostree_deployment_get_csum(d.native()))
+}
+
+// Deployserial returns the serial distinguishing multiple deployments of
+// the same commit
+func (d *Deployment) Deployserial() int {
+	defer runtime.KeepAlive(d)
+	return int(C.ostree_deployment_get_deployserial(d.native()))
+}
+
+// Bootcsum returns the checksum of the kernel and initramfs of the deployment
+func (d *Deployment) Bootcsum() string {
+	defer runtime.KeepAlive(d)
+	return C.GoString(C.ostree_deployment_get_bootcsum(d.native()))
+}
+
+// Bootserial returns the serial distinguishing deployments sharing a bootcsum
+func (d *Deployment) Bootserial() int {
+	defer runtime.KeepAlive(d)
+	return int(C.ostree_deployment_get_bootserial(d.native()))
+}
+
+// Index returns the position of the deployment in the boot order
+func (d *Deployment) Index() int {
+	defer runtime.KeepAlive(d)
+	return int(C.ostree_deployment_get_index(d.native()))
+}
+
+// Origin returns the contents of the origin file of the deployment, in
+// GKeyFile format, or an empty string if it has none
+func (d *Deployment) Origin() string {
+	defer runtime.KeepAlive(d)
+	origin := C.ostree_deployment_get_origin(d.native())
+	if origin == nil {
+		return ""
+	}
+	data := C.g_key_file_to_data(origin, nil, nil)
+	defer C.g_free(C.gpointer(data))
+	return C.GoString((*C.char)(data))
+}
+
+// OriginRefspec returns the refspec the deployment tracks, or an empty
+// string if it has none
+func (d *Deployment) OriginRefspec() string {
+	defer runtime.KeepAlive(d)
+	origin := C.ostree_deployment_get_origin(d.native())
+	if origin == nil {
+		return ""
+	}
+
+	groupC := C.CString("origin")
+	defer C.free(unsafe.Pointer(groupC))
+	keyC := C.CString("refspec")
+	defer C.free(unsafe.Pointer(keyC))
+	refspec := C.g_key_file_get_string(origin, (*C.gchar)(groupC), (*C.gchar)(keyC), nil)
+	defer C.g_free(C.gpointer(refspec))
+	return C.GoString((*C.char)(refspec))
+}
+
+// IsPinned returns whether the deployment is protected from garbage collection
+func (d *Deployment) IsPinned() bool {
+	defer runtime.KeepAlive(d)
+	return isOk(C.ostree_deployment_is_pinned(d.native()))
+}
+
+// IsStaged returns whether the deployment is staged for the next boot
+func (d *Deployment) IsStaged() bool {
+	defer runtime.KeepAlive(d)
+	return isOk(C.ostree_deployment_is_staged(d.native()))
+}
+
+// Unlocked returns the unlocked state of the deployment
+func (d *Deployment) Unlocked() UnlockedState {
+	defer runtime.KeepAlive(d)
+	return UnlockedState(C.ostree_deployment_get_unlocked(d.native()))
+}
+
+// BootConfig returns the boot loader configuration of the deployment, or
+// nil if it has none
+func (d *Deployment) BootConfig() *BootConfig {
+	defer runtime.KeepAlive(d)
+	return bootConfigFromNative(C.ostree_deployment_get_bootconfig(d.native()))
+}
+
+// Kargs returns the kernel arguments of the deployment, parsed from the
+// options of its boot loader configuration
+func (d *Deployment) Kargs() *kargs.Kargs {
+	bootconfig := d.BootConfig()
+	if bootconfig == nil {
+		return kargs.Parse("")
+	}
+	return kargs.Parse(bootconfig.Get("options"))
+}
+
+// BootConfig represents the boot loader configuration of a deployment
+type BootConfig struct {
+	ptr unsafe.Pointer
+}
+
+// bootConfigFromNative takes a C bootconfig parser, adds a reference to it
+// and converts it to a Go struct
+func bootConfigFromNative(cb *C.OstreeBootconfigParser) *BootConfig {
+	if cb == nil {
+		return nil
+	}
+	C.g_object_ref(C.gpointer(cb))
+	b := &BootConfig{unsafe.Pointer(cb)}
+	runtime.SetFinalizer(b, (*BootConfig).unref)
+	return b
+}
+
+// unref drops the reference held on the C bootconfig parser
+func (b *BootConfig) unref() {
+	C.g_object_unref(C.gpointer(b.ptr))
+}
+
+// native converts a bootconfig struct to its C equivalent
+func (b *BootConfig) native() *C.OstreeBootconfigParser {
+	if b == nil {
+		return nil
+	}
+	return (*C.OstreeBootconfigParser)(b.ptr)
+}
+
+// Get returns the value of the given key (e.g. "options" or "linux"), or an
+// empty string if it is not set
+func (b *BootConfig) Get(key string) string {
+	defer runtime.KeepAlive(b)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	return C.GoString(C.ostree_bootconfig_parser_get(b.native(), ckey))
+}
+
+// Set sets the value of the given key
+func (b *BootConfig) Set(key, value string) {
+	defer runtime.KeepAlive(b)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+	C.ostree_bootconfig_parser_set(b.native(), ckey, cvalue)
+}
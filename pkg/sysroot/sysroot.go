@@ -0,0 +1,147 @@
+// Package sysroot contains bindings for inspecting and managing an ostree
+// sysroot, the physical root filesystem of an ostree-booted system
+package sysroot
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	glib "github.com/ostreedev/ostree-go/pkg/glibobject"
+)
+
+// #cgo pkg-config: ostree-1
+// #include <stdlib.h>
+// #include <glib.h>
+// #include <ostree.h>
+// #include "sysroot.go.h"
+import "C"
+
+// Sysroot represents an ostree sysroot
+type Sysroot struct {
+	ptr unsafe.Pointer
+}
+
+// isInitialized checks if the sysroot has been initialized
+func (s *Sysroot) isInitialized() bool {
+	if s == nil || s.ptr == nil {
+		return false
+	}
+	return true
+}
+
+// native converts an ostree sysroot struct to its C equivalent
+func (s *Sysroot) native() *C.OstreeSysroot {
+	if !s.isInitialized() {
+		return nil
+	}
+	return (*C.OstreeSysroot)(s.ptr)
+}
+
+// Open opens and loads the sysroot at the given path
+func Open(path string) (*Sysroot, error) {
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	sysrootPath := C.g_file_new_for_path(cpath)
+	defer C.g_object_unref(C.gpointer(sysrootPath))
+	s := &Sysroot{unsafe.Pointer(C.ostree_sysroot_new(sysrootPath))}
+
+	if err := s.Load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases the underlying sysroot object.  The sysroot must not be
+// used afterwards.
+func (s *Sysroot) Close() {
+	if !s.isInitialized() {
+		return
+	}
+	C.g_object_unref(C.gpointer(s.ptr))
+	s.ptr = nil
+}
+
+// Load (re)loads the deployment state of the sysroot from disk
+func (s *Sysroot) Load() error {
+	if !s.isInitialized() {
+		return errors.New("sysroot not initialized")
+	}
+
+	var cerr *C.GError
+	if !isOk(C.ostree_sysroot_load(s.native(), nil, &cerr)) {
+		return generateError(cerr)
+	}
+	return nil
+}
+
+// Path returns the filesystem path of the sysroot
+func (s *Sysroot) Path() string {
+	if !s.isInitialized() {
+		return ""
+	}
+	cpath := C.g_file_get_path(C.ostree_sysroot_get_path(s.native()))
+	defer C.g_free(C.gpointer(cpath))
+	return C.GoString(cpath)
+}
+
+// Deployments returns all deployments of the sysroot, in boot order
+func (s *Sysroot) Deployments() []*Deployment {
+	if !s.isInitialized() {
+		return nil
+	}
+
+	cdeployments := C.ostree_sysroot_get_deployments(s.native())
+	defer C.g_ptr_array_unref(cdeployments)
+
+	deployments := make([]*Deployment, 0, int(cdeployments.len))
+	for i := C.guint(0); i < cdeployments.len; i++ {
+		cdeployment := (*C.OstreeDeployment)(C._g_ptr_array_index(cdeployments, i))
+		deployments = append(deployments, deploymentFromNative(cdeployment))
+	}
+	return deployments
+}
+
+// BootedDeployment returns the deployment the running system was booted
+// from, or nil if the sysroot is not the booted one
+func (s *Sysroot) BootedDeployment() *Deployment {
+	if !s.isInitialized() {
+		return nil
+	}
+	return deploymentFromNative(C.ostree_sysroot_get_booted_deployment(s.native()))
+}
+
+// StagedDeployment returns the deployment staged for the next boot, or nil
+// if there is none
+func (s *Sysroot) StagedDeployment() *Deployment {
+	if !s.isInitialized() {
+		return nil
+	}
+	return deploymentFromNative(C.ostree_sysroot_get_staged_deployment(s.native()))
+}
+
+// generateError wraps a GLib error into a Go one.
+func generateError(err *C.GError) error {
+	if err == nil {
+		return errors.New("nil GError")
+	}
+
+	goErr := glib.ConvertGError(glib.ToGError(unsafe.Pointer(err)))
+	_, file, line, ok := runtime.Caller(1)
+	if ok {
+		return fmt.Errorf("%s:%d - %s", file, line, goErr)
+	}
+	return goErr
+}
+
+// isOk wraps a gboolean return value into a bool.
+// 0 is false/error, everything else is true/ok.
+func isOk(v C.gboolean) bool {
+	return glib.GoBool(glib.GBoolean(v))
+}
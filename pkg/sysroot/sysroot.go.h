@@ -0,0 +1,30 @@
+#ifndef SYSROOT_GO_H
+#define SYSROOT_GO_H
+
+#include <glib.h>
+#include <ostree.h>
+
+// Wrapper for a macro since CGO can't parse macros
+static gpointer
+_g_ptr_array_index (GPtrArray *array,
+                    guint      index)
+{
+  return g_ptr_array_index (array, index);
+}
+
+// Helpers for building NULL-terminated string arrays from Go
+static char **
+_strv_new (int size)
+{
+  return g_new0 (char *, size + 1);
+}
+
+static void
+_strv_set (char       **strv,
+           int          index,
+           const char  *value)
+{
+  strv[index] = g_strdup (value);
+}
+
+#endif
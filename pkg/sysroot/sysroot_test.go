@@ -0,0 +1,196 @@
+package sysroot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ostreedev/ostree-go/pkg/otbuiltin"
+)
+
+// fakeDeployment describes a deployment written by fabricateSysroot
+type fakeDeployment struct {
+	osname   string
+	csum     string
+	bootcsum string
+	origin   string
+}
+
+// fabricateSysroot lays out a non-booted sysroot at sysrootDir with the given
+// deployments, mimicking what `ostree admin deploy` writes for bootversion 1
+func fabricateSysroot(t *testing.T, sysrootDir string, deployments []fakeDeployment) {
+	repoDir := path.Join(sysrootDir, "ostree", "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("failed to create repo dir: %s", err)
+	}
+	if _, err := otbuiltin.Init(repoDir, otbuiltin.NewInitOptions()); err != nil {
+		t.Fatalf("failed to initialize repo: %s", err)
+	}
+
+	entriesDir := path.Join(sysrootDir, "boot", "loader.1", "entries")
+	if err := os.MkdirAll(entriesDir, 0755); err != nil {
+		t.Fatalf("failed to create loader entries dir: %s", err)
+	}
+	if err := os.Symlink("loader.1", path.Join(sysrootDir, "boot", "loader")); err != nil {
+		t.Fatalf("failed to create loader symlink: %s", err)
+	}
+	if err := os.Symlink("boot.1.0", path.Join(sysrootDir, "ostree", "boot.1")); err != nil {
+		t.Fatalf("failed to create bootlink dir symlink: %s", err)
+	}
+
+	for i, d := range deployments {
+		stateroot := path.Join(sysrootDir, "ostree", "deploy", d.osname)
+		deployName := fmt.Sprintf("%s.0", d.csum)
+		if err := os.MkdirAll(path.Join(stateroot, "deploy", deployName, "usr", "etc"), 0755); err != nil {
+			t.Fatalf("failed to create deployment dir: %s", err)
+		}
+		if err := os.MkdirAll(path.Join(stateroot, "var"), 0755); err != nil {
+			t.Fatalf("failed to create stateroot var: %s", err)
+		}
+		if d.origin != "" {
+			if err := ioutil.WriteFile(path.Join(stateroot, "deploy", deployName+".origin"), []byte(d.origin), 0644); err != nil {
+				t.Fatalf("failed to write origin: %s", err)
+			}
+		}
+
+		bootlinkDir := path.Join(sysrootDir, "ostree", "boot.1.0", d.osname, d.bootcsum)
+		if err := os.MkdirAll(bootlinkDir, 0755); err != nil {
+			t.Fatalf("failed to create bootlink dir: %s", err)
+		}
+		target := path.Join("..", "..", "..", "deploy", d.osname, "deploy", deployName)
+		if err := os.Symlink(target, path.Join(bootlinkDir, "0")); err != nil {
+			t.Fatalf("failed to create bootlink: %s", err)
+		}
+
+		version := len(deployments) - i
+		entry := fmt.Sprintf("title %s %d\nversion %d\nlinux /ostree/%s-%s/vmlinuz\noptions root=/dev/vda1 ostree=/ostree/boot.1/%s/%s/0\n",
+			d.osname, version, version, d.osname, d.bootcsum, d.osname, d.bootcsum)
+		entryPath := path.Join(entriesDir, fmt.Sprintf("ostree-%d-%s.conf", version, d.osname))
+		if err := ioutil.WriteFile(entryPath, []byte(entry), 0644); err != nil {
+			t.Fatalf("failed to write loader entry: %s", err)
+		}
+	}
+}
+
+func TestOpenEmptySysroot(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "sysroot-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	fabricateSysroot(t, baseDir, nil)
+
+	s, err := Open(baseDir)
+	if err != nil {
+		t.Fatalf("failed to open sysroot: %s", err)
+	}
+	defer s.Close()
+
+	if s.Path() != baseDir {
+		t.Errorf("expected path %q, got %q", baseDir, s.Path())
+	}
+	if n := len(s.Deployments()); n != 0 {
+		t.Errorf("expected no deployments, got %d", n)
+	}
+	if s.BootedDeployment() != nil {
+		t.Error("expected no booted deployment")
+	}
+	if s.StagedDeployment() != nil {
+		t.Error("expected no staged deployment")
+	}
+}
+
+func TestOpenMissingSysroot(t *testing.T) {
+	if _, err := Open(""); err == nil {
+		t.Fatal("got unexpected nil error for empty path")
+	}
+	if _, err := Open("/nonexistent/sysroot"); err == nil {
+		t.Fatal("got unexpected nil error for missing sysroot")
+	}
+}
+
+func TestDeployments(t *testing.T) {
+	// Make a base directory in which all of our test data resides
+	baseDir, err := ioutil.TempDir("", "sysroot-test-")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	fakes := []fakeDeployment{
+		{
+			osname:   "fedora",
+			csum:     strings.Repeat("a", 64),
+			bootcsum: strings.Repeat("b", 64),
+			origin:   "[origin]\nrefspec=remote:fedora/x86_64/stable\n",
+		},
+		{
+			osname:   "fedora",
+			csum:     strings.Repeat("c", 64),
+			bootcsum: strings.Repeat("d", 64),
+			origin:   "[origin]\nrefspec=remote:fedora/x86_64/testing\n\n[libostree-transient]\npinned=true\n",
+		},
+	}
+	fabricateSysroot(t, baseDir, fakes)
+
+	s, err := Open(baseDir)
+	if err != nil {
+		t.Fatalf("failed to open sysroot: %s", err)
+	}
+	defer s.Close()
+
+	deployments := s.Deployments()
+	if len(deployments) != len(fakes) {
+		t.Fatalf("expected %d deployments, got %d", len(fakes), len(deployments))
+	}
+
+	for i, d := range deployments {
+		if d.Index() != i {
+			t.Errorf("deployment %d: expected index %d, got %d", i, i, d.Index())
+		}
+		if d.Osname() != fakes[i].osname {
+			t.Errorf("deployment %d: expected osname %q, got %q", i, fakes[i].osname, d.Osname())
+		}
+		if d.Csum() != fakes[i].csum {
+			t.Errorf("deployment %d: expected csum %q, got %q", i, fakes[i].csum, d.Csum())
+		}
+		if d.Deployserial() != 0 {
+			t.Errorf("deployment %d: expected deployserial 0, got %d", i, d.Deployserial())
+		}
+		if d.Bootcsum() != fakes[i].bootcsum {
+			t.Errorf("deployment %d: expected bootcsum %q, got %q", i, fakes[i].bootcsum, d.Bootcsum())
+		}
+		if d.Unlocked() != UnlockedNone {
+			t.Errorf("deployment %d: expected unlocked state %q, got %q", i, UnlockedNone, d.Unlocked())
+		}
+		if d.IsStaged() {
+			t.Errorf("deployment %d: unexpectedly staged", i)
+		}
+		if !strings.Contains(d.Origin(), "[origin]") {
+			t.Errorf("deployment %d: unexpected origin %q", i, d.Origin())
+		}
+
+		bootconfig := d.BootConfig()
+		if bootconfig == nil {
+			t.Fatalf("deployment %d: missing bootconfig", i)
+		}
+		if !strings.Contains(bootconfig.Get("options"), "root=/dev/vda1") {
+			t.Errorf("deployment %d: unexpected options %q", i, bootconfig.Get("options"))
+		}
+	}
+
+	if refspec := deployments[0].OriginRefspec(); refspec != "remote:fedora/x86_64/stable" {
+		t.Errorf("unexpected refspec %q", refspec)
+	}
+	if deployments[0].IsPinned() {
+		t.Error("first deployment unexpectedly pinned")
+	}
+	if !deployments[1].IsPinned() {
+		t.Error("second deployment should be pinned")
+	}
+}